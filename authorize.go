@@ -0,0 +1,67 @@
+package ion
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Errors returned by a Policy to deny a request
+var (
+	// ErrUnauthenticated denies the request because the client is not
+	// authenticated. It is answered with 401.
+	ErrUnauthenticated = errors.New("ion: authentication required")
+	// ErrForbidden denies the request because the client is not allowed
+	// to perform it. It is answered with 403.
+	ErrForbidden = errors.New("ion: forbidden")
+)
+
+// Policy decides whether a request may be handled. It returns nil to allow
+// it, or an error to deny it: 401 is answered if the error is (or wraps)
+// ErrUnauthenticated and 403 otherwise. If the error has (or wraps an
+// error with) a Challenges() []string method the challenges are sent in
+// the WWW-Authenticate header of 401 responses.
+//
+// Policies of Endpoints and routes run after the path arguments are stored
+// in the context, so they can compare them with the authenticated client.
+type Policy func(r *http.Request) error
+
+// Authorize returns a Middleware that evaluates the policies in order,
+// handling the request only if all of them allow it.
+func Authorize(policies ...Policy) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := Evaluate(r, policies...); err != nil {
+				Deny(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Evaluate returns the first error returned by the policies, or nil
+// if all of them allow the request.
+func Evaluate(r *http.Request, policies ...Policy) error {
+	for _, policy := range policies {
+		if err := policy(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deny answers a request denied by a Policy with the given error, in the
+// format requested by the client like the errors of the middleware
+func Deny(w http.ResponseWriter, r *http.Request, err error) {
+	if !errors.Is(err, ErrUnauthenticated) {
+		WriteError(w, r, http.StatusForbidden, "", "")
+		return
+	}
+	var c interface{ Challenges() []string }
+	if errors.As(err, &c) {
+		for _, challenge := range c.Challenges() {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+	WriteError(w, r, http.StatusUnauthorized, "", "")
+}
@@ -4,8 +4,11 @@ package router
 
 import (
 	"context"
+	"github.com/estebarb/ion"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 )
 
 // Router register routes to be matched and
@@ -31,6 +34,8 @@ type route struct {
 	parsedPath []string
 	name       string
 	method     string
+	timeout    time.Duration
+	policies   []ion.Policy
 }
 
 func splitWithoutTrailingSlash(str string) []string {
@@ -111,11 +116,20 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		values, eq := equalPath(path, route.parsedPath)
 		if eq {
 			http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				req = ion.MatchRoute(req, route.path, route.name)
+				if route.timeout != 0 {
+					ion.OverrideTimeout(req.Context(), route.timeout)
+				}
 				ctx := req.Context()
 				for k, v := range values {
 					ctx = context.WithValue(ctx, k, v)
 				}
-				route.handler.ServeHTTP(w, req.WithContext(ctx))
+				req = req.WithContext(ctx)
+				if err := ion.Evaluate(req, route.policies...); err != nil {
+					ion.Deny(w, req, err)
+					return
+				}
+				route.handler.ServeHTTP(w, req)
 			}).ServeHTTP(w, req)
 			return
 		}
@@ -123,6 +137,23 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	http.NotFound(w, req)
 }
 
+// MethodsFor returns the sorted list of methods that have a route
+// matching the given path.
+func (r *Router) MethodsFor(path string) []string {
+	parsedPath := splitWithoutTrailingSlash(path)
+	var methods []string
+	for method, routes := range r.routeByMethod {
+		for _, route := range routes {
+			if _, eq := equalPath(parsedPath, route.parsedPath); eq {
+				methods = append(methods, method)
+				break
+			}
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
 func equalPath(path, pattern []string) (map[string]string, bool) {
 	values := make(map[string]string)
 	if len(path) != len(pattern) {
@@ -152,10 +183,25 @@ type Route struct {
 func (r *Route) Name(name string) *Route {
 	if name != "" {
 		r.router.routeByName[name] = r.route
+		r.route.name = name
 	}
 	return r
 }
 
+// Timeout overrides the time given to handle the requests matching the
+// Route by the timeout middleware. See ion.OverrideTimeout.
+func (r *Route) Timeout(d time.Duration) *Route {
+	r.route.timeout = d
+	return r
+}
+
+// Authorize adds policies that must allow the requests matching the Route.
+// They are evaluated after the path arguments are stored in the context.
+func (r *Route) Authorize(policies ...ion.Policy) *Route {
+	r.route.policies = append(r.route.policies, policies...)
+	return r
+}
+
 // Get register the handler in the router, after wrapping it with the middleware
 func (r *Router) Get(path string, handler http.Handler) *Route {
 	return r.Handler(http.MethodGet, path, handler)
@@ -2,6 +2,7 @@ package router
 
 import (
 	"fmt"
+	"github.com/estebarb/ion"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -211,3 +212,32 @@ func TestHandlers(t *testing.T) {
 		}
 	}
 }
+
+func TestRoutePattern(t *testing.T) {
+	r := New()
+	var pattern, name string
+	r.GetFunc("/hello/:name", func(w http.ResponseWriter, req *http.Request) {
+		pattern = ion.RoutePattern(req.Context())
+		name = ion.RouteName(req.Context())
+	}).Name("hello")
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello/world", nil))
+	if pattern != "/hello/:name" {
+		t.Errorf("Expecting pattern /hello/:name, got %s", pattern)
+	}
+	if name != "hello" {
+		t.Errorf("Expecting name hello, got %s", name)
+	}
+}
+
+func TestRouter_MethodsFor(t *testing.T) {
+	r := New()
+	r.GetFunc("/users/:id", dummy)
+	r.PutFunc("/users/:id", dummy)
+	r.DeleteFunc("/users/:id", dummy)
+	r.PostFunc("/users", dummy)
+
+	testEq(t, r.MethodsFor("/users/42"), []string{"DELETE", "GET", "PUT"})
+	testEq(t, r.MethodsFor("/users/"), []string{"POST"})
+	testEq(t, r.MethodsFor("/other"), nil)
+}
@@ -0,0 +1,51 @@
+package ion
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+)
+
+// WriteError writes an error response in the format preferred by the
+// client: JSON, HTML or plain text. The detail, if not empty, is included
+// after the message.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, message, detail string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("X-Content-Type-Options", "nosniff")
+	switch NegotiateContentType(r, "text/plain", "application/json", "text/html") {
+	case "application/json":
+		h.Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(struct {
+			Status  int    `json:"status"`
+			Error   string `json:"error"`
+			Message string `json:"message,omitempty"`
+			Detail  string `json:"detail,omitempty"`
+		}{status, http.StatusText(status), message, detail})
+	case "text/html":
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>%d %s</title></head><body>\n<h1>%d %s</h1>\n",
+			status, html.EscapeString(http.StatusText(status)),
+			status, html.EscapeString(http.StatusText(status)))
+		if message != "" {
+			fmt.Fprintf(w, "<p>%s</p>\n", html.EscapeString(message))
+		}
+		if detail != "" {
+			fmt.Fprintf(w, "<pre>%s</pre>\n", html.EscapeString(detail))
+		}
+		fmt.Fprint(w, "</body></html>\n")
+	default:
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		if message == "" {
+			message = http.StatusText(status)
+		}
+		fmt.Fprintln(w, message)
+		if detail != "" {
+			fmt.Fprintf(w, "\n%s\n", detail)
+		}
+	}
+}
@@ -5,46 +5,143 @@
 // (for example, database queries) had been finished.
 package futures
 
-import "sync"
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicError is the error of a Future whose function panicked
+type PanicError struct {
+	// Value is the value passed to panic
+	Value interface{}
+	// Stack is the stack trace of the goroutine when it panicked
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("futures: panic: %v", e.Value)
+}
+
+// Unwrap returns the value passed to panic, if it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
 
 // Future represents an maybe incomplete operation, that is
 // being processed
-type Future struct {
+type Future[T any] struct {
+	// start begins the computation of the lazy Futures, once
+	start func()
 	once  sync.Once
-	input chan interface{}
-	value interface{}
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// Reader is implemented by all the Futures, so that they can be read
+// without knowing the type of their values, like in templates
+type Reader interface {
+	Result() (interface{}, error)
 }
 
-// NewFuture creates a Future from an input channel
-func NewFuture(input chan interface{}) *Future {
-	f := &Future{
-		input: input,
-		value: nil,
+func newFuture[T any]() *Future[T] {
+	return &Future[T]{done: make(chan struct{})}
+}
+
+// run completes the Future with the result of f, converting its panics
+// into a PanicError
+func (f *Future[T]) run(fn func() (T, error)) {
+	defer func() {
+		if p := recover(); p != nil {
+			f.err = &PanicError{Value: p, Stack: debug.Stack()}
+			close(f.done)
+		}
+	}()
+	f.value, f.err = fn()
+	close(f.done)
+}
+
+// NewFuture creates a Future from an input channel, with the first value
+// received from it. The channel is not read until the value is requested.
+func NewFuture[T any](input chan T) *Future[T] {
+	f := newFuture[T]()
+	f.start = func() {
+		go f.run(func() (T, error) {
+			return <-input, nil
+		})
 	}
 	return f
 }
 
 // NewFutureFunc creates a Future from a function that
-// returns an interface{}
-func NewFutureFunc(f func() interface{}) *Future {
-	c := make(chan interface{})
-	go func() {
-		c <- f()
-	}()
-	return NewFuture(c)
+// returns a value
+func NewFutureFunc[T any](fn func() T) *Future[T] {
+	return NewFutureErr(func() (T, error) {
+		return fn(), nil
+	})
 }
 
-// Read blocks until the computation finish,
-// and then returns the value.
-func (f *Future) Read() interface{} {
-	f.once.Do(func() {
-		f.value = <-f.input
+// NewFutureErr creates a Future from a function that returns a value or
+// an error. If the function panics the Future fails with a PanicError.
+func NewFutureErr[T any](fn func() (T, error)) *Future[T] {
+	f := newFuture[T]()
+	go f.run(fn)
+	return f
+}
+
+// NewFutureContext creates a Future from a function that receives ctx,
+// so that it can stop when the context is cancelled
+func NewFutureContext[T any](ctx context.Context, fn func(ctx context.Context) (T, error)) *Future[T] {
+	return NewFutureErr(func() (T, error) {
+		return fn(ctx)
 	})
+}
+
+// wait returns the channel closed when the computation finishes, starting
+// it if the Future is lazy
+func (f *Future[T]) wait() <-chan struct{} {
+	if f.start != nil {
+		f.once.Do(f.start)
+	}
+	return f.done
+}
+
+// Done returns a channel that is closed when the computation finishes
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.wait()
+}
+
+// Get blocks until the computation finishes, and then returns its value
+// and error. It returns the error of ctx if it is done before.
+func (f *Future[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-f.wait():
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Read blocks until the computation finish,
+// and then returns the value. The error, if any, is ignored.
+func (f *Future[T]) Read() interface{} {
+	<-f.wait()
 	return f.value
 }
 
-// Read reads the Future value. Is intended to
-// be used on templates.
-func Read(f *Future) interface{} {
-	return f.Read()
+// Result blocks until the computation finishes, and then returns its
+// value and error. It implements Reader.
+func (f *Future[T]) Result() (interface{}, error) {
+	<-f.wait()
+	return f.value, f.err
+}
+
+// Read reads the Future value and error. Is intended to
+// be used on templates, where the error stops the execution.
+func Read(f Reader) (interface{}, error) {
+	return f.Result()
 }
@@ -1,7 +1,11 @@
 package futures
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"text/template"
 	"time"
 )
 
@@ -30,7 +34,10 @@ func TestNewFutureFunc(t *testing.T) {
 			"second, but takes: ", readTime.Sub(afterCreate))
 	}
 
-	y := Read(valueF)
+	y, err := Read(valueF)
+	if err != nil {
+		t.Error("Future must not fail, got", err)
+	}
 
 	if x.(int) != 1 {
 		t.Error("Future value must be 1")
@@ -40,3 +47,74 @@ func TestNewFutureFunc(t *testing.T) {
 		t.Error("Future value must be 1")
 	}
 }
+
+func TestNewFutureErr(t *testing.T) {
+	errFailed := errors.New("failed")
+	ok := NewFutureErr(func() (string, error) { return "hello", nil })
+	failed := NewFutureErr(func() (string, error) { return "", errFailed })
+	panicked := NewFutureErr(func() (string, error) { panic("boom") })
+	ctx := context.Background()
+
+	if value, err := ok.Get(ctx); value != "hello" || err != nil {
+		t.Errorf("Expected 'hello', got %q %v", value, err)
+	}
+	if _, err := failed.Get(ctx); err != errFailed {
+		t.Errorf("Expected the error of the function, got %v", err)
+	}
+	_, err := panicked.Get(ctx)
+	var perr *PanicError
+	if !errors.As(err, &perr) || perr.Value != "boom" || len(perr.Stack) == 0 {
+		t.Errorf("Expected a PanicError, got %v", err)
+	}
+	<-panicked.Done()
+}
+
+func TestFuture_GetCancelled(t *testing.T) {
+	release := make(chan int)
+	f := NewFuture(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.Get(ctx); err != context.Canceled {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+	release <- 1
+	if value, err := f.Get(context.Background()); value != 1 || err != nil {
+		t.Errorf("Expected 1, got %v %v", value, err)
+	}
+}
+
+func TestRead_Template(t *testing.T) {
+	tmpl := template.Must(template.New("").Funcs(template.FuncMap{"read": Read}).
+		Parse(`{{read .Name}} is {{read .Age}}`))
+	data := map[string]Reader{
+		"Name": NewFutureFunc(func() string { return "Ana" }),
+		"Age":  NewFutureFunc(func() int { return 30 }),
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "Ana is 30" {
+		t.Errorf("Expected 'Ana is 30', got %q", b.String())
+	}
+
+	errFailed := errors.New("query failed")
+	data["Age"] = NewFutureErr(func() (int, error) { return 0, errFailed })
+	if err := tmpl.Execute(&strings.Builder{}, data); !errors.Is(err, errFailed) {
+		t.Errorf("Expected the error of the Future, got %v", err)
+	}
+}
+
+func TestNewFuture_Lazy(t *testing.T) {
+	input := make(chan int)
+	f := NewFuture(input)
+	select {
+	case input <- 1:
+		t.Error("Expected the channel not to be read before the value is requested")
+	case <-time.After(10 * time.Millisecond):
+	}
+	go func() { input <- 2 }()
+	if value := f.Read(); value != 2 {
+		t.Errorf("Expected 2, got %v", value)
+	}
+}
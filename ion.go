@@ -11,14 +11,15 @@
 // - Easily describe paths (with arguments) and method handlers
 // - Compatible with Middlewares
 // - Use context for passing path arguments
+// - Pluggable structured logging, compatible with log/slog
 //
 package ion
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // Middleware is a function that wrap an http.Handler and returns a value
@@ -44,6 +45,12 @@ type Endpoint struct {
 	Middleware  []Middleware
 	Handler     Builder
 	HttpHandler http.Handler
+	// Timeout, if not zero, overrides the time given to handle the
+	// request by the timeout middleware. See OverrideTimeout.
+	Timeout time.Duration
+	// Authorize lists the policies that must allow the request. They are
+	// evaluated after the Middleware, just before the handler.
+	Authorize []Policy
 }
 
 // Build generates an http.Handler from an Endpoint
@@ -52,10 +59,26 @@ func (e Endpoint) Build() http.Handler {
 		panic("Endpoint support only Handler or HttpHandler, not both")
 	}
 
+	chain := append(Chain{}, e.Middleware...)
+	if e.Timeout != 0 {
+		chain = append(Chain{overrideTimeout(e.Timeout)}, chain...)
+	}
+	if len(e.Authorize) > 0 {
+		chain = append(chain, Authorize(e.Authorize...))
+	}
 	if e.HttpHandler != nil {
-		return Chain(e.Middleware).Then(e.HttpHandler)
+		return chain.Then(e.HttpHandler)
+	}
+	return chain.Then(e.Handler.Build())
+}
+
+func overrideTimeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			OverrideTimeout(r.Context(), d)
+			next.ServeHTTP(w, r)
+		})
 	}
-	return Chain(e.Middleware).Then(e.Handler.Build())
 }
 
 // Builder interface is implemented by objects that can be build
@@ -79,7 +102,7 @@ func (r Routes) Build() http.Handler {
 			name := parts[0]
 			argHandler := captureArgument(name)(endpoint.Build())
 			if _, ok := r["/"]; ok {
-				rootHandler := r["/"].Build()
+				rootHandler := markRoute("/")(r["/"].Build())
 				mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 					if req.URL.Path == "/" {
 						rootHandler.ServeHTTP(w, req)
@@ -95,12 +118,12 @@ func (r Routes) Build() http.Handler {
 		} else if strings.HasPrefix(prefix, "/") {
 			continue
 		} else {
-			mux.Handle(prefix, http.StripPrefix(prefix, endpoint.Build()))
+			mux.Handle(prefix, markRoute(prefix)(http.StripPrefix(prefix, endpoint.Build())))
 		}
 	}
 
 	if !rootHandled {
-		mux.Handle("/", r["/"].Build())
+		mux.Handle("/", markRoute("/")(r["/"].Build()))
 	}
 	return mux
 }
@@ -109,7 +132,6 @@ func (r Routes) Build() http.Handler {
 // The request is handled if the path is "/" or "".
 func PathEnd(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Println(r.URL.Path)
 		if r.URL.Path != "/" && r.URL.Path != "" {
 			http.NotFound(w, r)
 		} else {
@@ -118,6 +140,14 @@ func PathEnd(handler http.Handler) http.Handler {
 	})
 }
 
+func markRoute(pattern string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, MatchRoute(r, pattern, ""))
+		})
+	}
+}
+
 func captureArgument(name string) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -126,8 +156,8 @@ func captureArgument(name string) Middleware {
 			if len(parts) > 0 {
 				value = parts[0]
 			}
+			r = MatchRoute(r, "/:"+name, "")
 			ctx := context.WithValue(r.Context(), name, value)
-			log.Println(name, value)
 			r2 := r.WithContext(ctx)
 
 			http.StripPrefix("/"+value, next).ServeHTTP(w, r2)
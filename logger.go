@@ -0,0 +1,100 @@
+package ion
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Logger is a structured logger. The arguments following the message are
+// alternating keys and values, so *slog.Logger satisfies this interface.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// DefaultLogger is returned by LoggerFrom when the request has no Logger
+// configured with WithLogger.
+var DefaultLogger Logger = NewStdLogger(nil)
+
+// DiscardLogger is a Logger that drops every message.
+var DiscardLogger Logger = discardLogger{}
+
+type loggerKey struct{}
+
+// WithLogger returns a Middleware that makes the given Logger available to
+// the handlers it wraps through LoggerFrom.
+func WithLogger(logger Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), loggerKey{}, logger)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// LoggerFrom returns the Logger stored in the context by WithLogger, or
+// DefaultLogger if there is none.
+func LoggerFrom(ctx context.Context) Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(Logger); ok && logger != nil {
+		return logger
+	}
+	return DefaultLogger
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Debug(msg string, args ...interface{}) {}
+func (discardLogger) Info(msg string, args ...interface{})  {}
+func (discardLogger) Warn(msg string, args ...interface{})  {}
+func (discardLogger) Error(msg string, args ...interface{}) {}
+
+// stdLogger writes key=value lines through a *log.Logger
+type stdLogger struct {
+	l *log.Logger
+}
+
+// NewStdLogger returns a Logger that writes messages as key=value lines
+// through l. If l is nil the standard logger from package log is used.
+// Debug messages are discarded.
+func NewStdLogger(l *log.Logger) Logger {
+	return stdLogger{l: l}
+}
+
+func (s stdLogger) Debug(msg string, args ...interface{}) {}
+
+func (s stdLogger) Info(msg string, args ...interface{}) {
+	s.output("INFO", msg, args)
+}
+
+func (s stdLogger) Warn(msg string, args ...interface{}) {
+	s.output("WARN", msg, args)
+}
+
+func (s stdLogger) Error(msg string, args ...interface{}) {
+	s.output("ERROR", msg, args)
+}
+
+func (s stdLogger) output(level, msg string, args []interface{}) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		b.WriteByte(' ')
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, "%v=%q", args[i], fmt.Sprint(args[i+1]))
+		} else {
+			fmt.Fprintf(&b, "!BADKEY=%q", fmt.Sprint(args[i]))
+		}
+	}
+	if s.l != nil {
+		s.l.Output(3, b.String())
+	} else {
+		log.Output(3, b.String())
+	}
+}
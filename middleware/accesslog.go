@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/estebarb/ion"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// AccessLogEntry contains the information recorded about a request
+// by AccessLog
+type AccessLogEntry struct {
+	Time       time.Time
+	Duration   time.Duration
+	RemoteAddr string
+	User       string
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Bytes      int
+	Referer    string
+	UserAgent  string
+	Route      string
+	RequestID  string
+}
+
+// AccessLogFormat writes an AccessLogEntry to w. It must write
+// the whole entry, including the trailing new line.
+type AccessLogFormat func(w io.Writer, e *AccessLogEntry) error
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+func clfValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func clfBytes(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.Itoa(n)
+}
+
+// CommonLogFormat writes entries in the Apache Common Log Format
+func CommonLogFormat(w io.Writer, e *AccessLogEntry) error {
+	_, err := fmt.Fprintf(w, "%s - %s [%s] \"%s %s %s\" %d %s\n",
+		clfValue(e.RemoteAddr),
+		clfValue(e.User),
+		e.Time.Format(clfTimeFormat),
+		e.Method, e.URI, e.Proto,
+		e.Status,
+		clfBytes(e.Bytes))
+	return err
+}
+
+// CombinedLogFormat writes entries in the Apache Combined Log Format
+func CombinedLogFormat(w io.Writer, e *AccessLogEntry) error {
+	_, err := fmt.Fprintf(w, "%s - %s [%s] \"%s %s %s\" %d %s %q %q\n",
+		clfValue(e.RemoteAddr),
+		clfValue(e.User),
+		e.Time.Format(clfTimeFormat),
+		e.Method, e.URI, e.Proto,
+		e.Status,
+		clfBytes(e.Bytes),
+		clfValue(e.Referer),
+		clfValue(e.UserAgent))
+	return err
+}
+
+// JSONLogFormat writes entries as JSON objects, one per line
+func JSONLogFormat(w io.Writer, e *AccessLogEntry) error {
+	return json.NewEncoder(w).Encode(struct {
+		Time       time.Time `json:"time"`
+		Duration   float64   `json:"duration_ms"`
+		RemoteAddr string    `json:"remote_addr"`
+		User       string    `json:"user,omitempty"`
+		Method     string    `json:"method"`
+		URI        string    `json:"uri"`
+		Proto      string    `json:"proto"`
+		Status     int       `json:"status"`
+		Bytes      int       `json:"bytes"`
+		Referer    string    `json:"referer,omitempty"`
+		UserAgent  string    `json:"user_agent,omitempty"`
+		Route      string    `json:"route,omitempty"`
+		RequestID  string    `json:"request_id,omitempty"`
+	}{
+		Time:       e.Time,
+		Duration:   float64(e.Duration) / float64(time.Millisecond),
+		RemoteAddr: e.RemoteAddr,
+		User:       e.User,
+		Method:     e.Method,
+		URI:        e.URI,
+		Proto:      e.Proto,
+		Status:     e.Status,
+		Bytes:      e.Bytes,
+		Referer:    e.Referer,
+		UserAgent:  e.UserAgent,
+		Route:      e.Route,
+		RequestID:  e.RequestID,
+	})
+}
+
+// TemplateLogFormat returns an AccessLogFormat that executes the given
+// text/template with the AccessLogEntry as data. A new line is appended
+// after each entry.
+func TemplateLogFormat(text string) (AccessLogFormat, error) {
+	tmpl, err := template.New("accesslog").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return func(w io.Writer, e *AccessLogEntry) error {
+		if err := tmpl.Execute(w, e); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	}, nil
+}
+
+// AccessLog returns a middleware that writes an entry to out for every
+// request, formatted with the given AccessLogFormat. Entries are written
+// with a single Write call each, serialized within the middleware. A
+// writer shared between several AccessLog middleware must be safe for
+// concurrent use.
+//
+// The User of the entries is the Subject of the Principal authenticated
+// by the inner middleware, like BasicAuthConfig or jwt.Config.
+func AccessLog(out io.Writer, format AccessLogFormat) ion.Middleware {
+	var l sync.Mutex
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := WrapResponseWriter(w)
+			r = ion.TrackRoute(r)
+			r, principal := trackPrincipal(r)
+			next.ServeHTTP(rw, r)
+
+			entry := &AccessLogEntry{
+				Time:       start,
+				Duration:   time.Since(start),
+				RemoteAddr: remoteHost(r),
+				Method:     r.Method,
+				URI:        r.RequestURI,
+				Proto:      r.Proto,
+				Status:     rw.Status(),
+				Bytes:      rw.BytesWritten(),
+				Referer:    r.Referer(),
+				UserAgent:  r.UserAgent(),
+				Route:      ion.RoutePattern(r.Context()),
+				RequestID:  RequestIDFrom(r.Context()),
+			}
+			if entry.URI == "" {
+				entry.URI = r.URL.RequestURI()
+			}
+			if entry.Status == 0 {
+				entry.Status = http.StatusOK
+			}
+			if p := principal.get(); p != nil {
+				entry.User = p.Subject
+			}
+
+			var buf bytes.Buffer
+			if err := format(&buf, entry); err != nil {
+				ion.LoggerFrom(r.Context()).Error("access log",
+					"error", err.Error())
+				return
+			}
+			l.Lock()
+			out.Write(buf.Bytes())
+			l.Unlock()
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func accessLogRequest(t *testing.T, format AccessLogFormat) string {
+	var out bytes.Buffer
+	auth := BasicAuthConfig{Verify: StaticBasic(map[string]string{"frank": "secret"})}
+	h := AccessLog(&out, format)(auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not here"))
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/missing?x=1", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("Referer", "http://example.com/")
+	req.Header.Set("User-Agent", "test")
+	req.SetBasicAuth("frank", "secret")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	return out.String()
+}
+
+func TestAccessLog_Common(t *testing.T) {
+	line := accessLogRequest(t, CommonLogFormat)
+	if !strings.HasPrefix(line, "10.0.0.1 - frank [") {
+		t.Errorf("Unexpected prefix: %q", line)
+	}
+	if !strings.HasSuffix(line, "\"GET /missing?x=1 HTTP/1.1\" 404 8\n") {
+		t.Errorf("Unexpected suffix: %q", line)
+	}
+}
+
+func TestAccessLog_Combined(t *testing.T) {
+	line := accessLogRequest(t, CombinedLogFormat)
+	if !strings.HasSuffix(line, "404 8 \"http://example.com/\" \"test\"\n") {
+		t.Errorf("Unexpected suffix: %q", line)
+	}
+}
+
+func TestAccessLog_JSON(t *testing.T) {
+	line := accessLogRequest(t, JSONLogFormat)
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["status"] != float64(404) || entry["bytes"] != float64(8) {
+		t.Errorf("Unexpected entry: %v", entry)
+	}
+}
+
+func TestAccessLog_Template(t *testing.T) {
+	format, err := TemplateLogFormat("{{.Method}} {{.URI}} {{.Status}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := accessLogRequest(t, format)
+	if line != "GET /missing?x=1 404\n" {
+		t.Errorf("Unexpected line: %q", line)
+	}
+}
+
+func TestWrapResponseWriter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := WrapResponseWriter(w)
+		if _, ok := rw.(http.Flusher); !ok {
+			t.Error("Expecting an http.Flusher")
+		}
+		if _, ok := rw.(http.Hijacker); !ok {
+			t.Error("Expecting an http.Hijacker")
+		}
+		if _, ok := rw.(http.Pusher); ok {
+			t.Error("Not expecting an http.Pusher")
+		}
+		rw.(http.Flusher).Flush()
+		if rw.Status() != http.StatusOK {
+			t.Errorf("Expecting status 200 after flush, got %d", rw.Status())
+		}
+	}))
+	defer ts.Close()
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	rw := WrapResponseWriter(httptest.NewRecorder())
+	if _, ok := rw.(http.Hijacker); ok {
+		t.Error("Not expecting an http.Hijacker")
+	}
+	if WrapResponseWriter(rw) != rw {
+		t.Error("Expecting an already wrapped writer to be returned unchanged")
+	}
+}
+
+func TestAccessLog_UnverifiedUser(t *testing.T) {
+	var out bytes.Buffer
+	h := AccessLog(&out, CommonLogFormat)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("frank", "wrong")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(out.String(), " - - [") {
+		t.Errorf("Expected no user without authentication, got %q", out.String())
+	}
+}
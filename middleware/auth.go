@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"github.com/estebarb/ion"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidCredentials is returned by verifiers when the credentials
+// are not valid
+var ErrInvalidCredentials = errors.New("middleware: invalid credentials")
+
+// Principal is the authenticated client of a request
+type Principal struct {
+	// Subject identifies the client, for example the user name
+	Subject string
+	// Scheme is the authentication scheme used, like "Basic" or "Bearer"
+	Scheme string
+	// Roles and Scopes granted to the client
+	Roles  []string
+	Scopes []string
+	// Attributes contains additional information about the client
+	Attributes map[string]interface{}
+}
+
+type principalKey struct{}
+
+type principalTrackerKey struct{}
+
+// principalTracker records the Principal authenticated by the inner
+// middleware, for the outer ones like AccessLog
+type principalTracker struct {
+	sync.Mutex
+	principal *Principal
+}
+
+// trackPrincipal returns a request whose context records the Principal
+// authenticated by the handlers that receive it
+func trackPrincipal(r *http.Request) (*http.Request, *principalTracker) {
+	t := &principalTracker{}
+	return r.WithContext(context.WithValue(r.Context(), principalTrackerKey{}, t)), t
+}
+
+func (t *principalTracker) get() *Principal {
+	t.Lock()
+	defer t.Unlock()
+	return t.principal
+}
+
+// WithPrincipal returns a copy of ctx that carries p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	if t, ok := ctx.Value(principalTrackerKey{}).(*principalTracker); ok {
+		t.Lock()
+		t.principal = p
+		t.Unlock()
+	}
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the Principal authenticated for the request,
+// if any
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// BasicVerifier verifies a user and password, returning the Principal
+// they belong to
+type BasicVerifier func(ctx context.Context, user, password string) (*Principal, error)
+
+// TokenVerifier verifies a bearer token or API key, returning the Principal
+// it belongs to
+type TokenVerifier func(ctx context.Context, token string) (*Principal, error)
+
+// secureCompare compares two strings in constant time, regardless of
+// their lengths
+func secureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// StaticBasic returns a BasicVerifier that accepts the given users and
+// passwords. The comparisons are done in constant time.
+func StaticBasic(passwords map[string]string) BasicVerifier {
+	return func(ctx context.Context, user, password string) (*Principal, error) {
+		expected, ok := passwords[user]
+		if !ok {
+			// Compare anyway, to not reveal which users exist
+			expected = "\x00"
+		}
+		if !secureCompare(password, expected) || !ok {
+			return nil, ErrInvalidCredentials
+		}
+		return &Principal{Subject: user, Scheme: "Basic"}, nil
+	}
+}
+
+// StaticTokens returns a TokenVerifier that accepts the given tokens, mapped
+// to the subject they belong to. Every token is compared in constant time.
+func StaticTokens(scheme string, tokens map[string]string) TokenVerifier {
+	return func(ctx context.Context, token string) (*Principal, error) {
+		var subject string
+		found := false
+		for t, s := range tokens {
+			if secureCompare(token, t) {
+				subject, found = s, true
+			}
+		}
+		if !found {
+			return nil, ErrInvalidCredentials
+		}
+		return &Principal{Subject: subject, Scheme: scheme}, nil
+	}
+}
+
+type challengesKey struct{}
+
+func withChallenge(r *http.Request, challenge string) *http.Request {
+	previous, _ := r.Context().Value(challengesKey{}).([]string)
+	challenges := append(append([]string{}, previous...), challenge)
+	return r.WithContext(context.WithValue(r.Context(), challengesKey{}, challenges))
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, challenge string) {
+	if challenge != "" {
+		r = withChallenge(r, challenge)
+	}
+	challenges, _ := r.Context().Value(challengesKey{}).([]string)
+	for _, c := range challenges {
+		w.Header().Add("WWW-Authenticate", c)
+	}
+	ion.WriteError(w, r, http.StatusUnauthorized, "authentication required", "")
+}
+
+// authenticate runs the common logic of the authentication middleware.
+// verify checks the credentials of the request, reporting whether there
+// were any. The invalid challenge is sent when the verification fails.
+func authenticate(next http.Handler, optional bool, challenge, invalid string,
+	verify func(r *http.Request) (*Principal, bool, error)) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := PrincipalFrom(r.Context()); ok {
+			// Already authenticated by a previous middleware
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, present, err := verify(r)
+		switch {
+		case !present && optional:
+			next.ServeHTTP(w, withChallenge(r, challenge))
+		case !present:
+			unauthorized(w, r, challenge)
+		case err != nil || principal == nil:
+			unauthorized(w, r, invalid)
+		default:
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// BasicAuthConfig configures HTTP Basic authentication
+type BasicAuthConfig struct {
+	Realm  string
+	Verify BasicVerifier
+	// Optional lets requests without credentials through, so that other
+	// authentication middleware can handle them. See RequireAuthentication.
+	Optional bool
+}
+
+// Middleware authenticates the requests with HTTP Basic authentication,
+// storing the Principal in the context. Requests with invalid or missing
+// credentials are answered with 401 and a WWW-Authenticate challenge.
+func (c BasicAuthConfig) Middleware(next http.Handler) http.Handler {
+	challenge := "Basic realm=" + strconv.Quote(c.Realm) + `, charset="UTF-8"`
+	return authenticate(next, c.Optional, challenge, challenge, func(r *http.Request) (*Principal, bool, error) {
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			return nil, false, nil
+		}
+		p, err := c.Verify(r.Context(), user, password)
+		return p, true, err
+	})
+}
+
+// BearerAuthConfig configures bearer token authentication (RFC 6750)
+type BearerAuthConfig struct {
+	Realm  string
+	Verify TokenVerifier
+	// Optional lets requests without credentials through, so that other
+	// authentication middleware can handle them. See RequireAuthentication.
+	Optional bool
+}
+
+// BearerToken returns the bearer token of the Authorization header
+func BearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+// Middleware authenticates the requests with a bearer token, storing the
+// Principal in the context. Requests with invalid or missing tokens are
+// answered with 401 and a WWW-Authenticate challenge.
+func (c BearerAuthConfig) Middleware(next http.Handler) http.Handler {
+	challenge := "Bearer realm=" + strconv.Quote(c.Realm)
+	invalid := challenge + `, error="invalid_token"`
+	return authenticate(next, c.Optional, challenge, invalid, func(r *http.Request) (*Principal, bool, error) {
+		token, ok := BearerToken(r)
+		if !ok {
+			return nil, false, nil
+		}
+		p, err := c.Verify(r.Context(), token)
+		return p, true, err
+	})
+}
+
+// APIKeyConfig configures API key authentication
+type APIKeyConfig struct {
+	// Header containing the key. X-API-Key is used if both Header and
+	// Query are empty.
+	Header string
+	// Query is the name of the query parameter containing the key
+	Query  string
+	Verify TokenVerifier
+	// Optional lets requests without credentials through, so that other
+	// authentication middleware can handle them. See RequireAuthentication.
+	Optional bool
+}
+
+// Middleware authenticates the requests with an API key, storing the
+// Principal in the context. Requests with invalid or missing keys are
+// answered with 401.
+func (c APIKeyConfig) Middleware(next http.Handler) http.Handler {
+	header := c.Header
+	if header == "" && c.Query == "" {
+		header = "X-API-Key"
+	}
+	challenge := "APIKey"
+	if header != "" {
+		challenge += " header=" + strconv.Quote(header)
+	}
+	return authenticate(next, c.Optional, challenge, challenge, func(r *http.Request) (*Principal, bool, error) {
+		var key string
+		if header != "" {
+			key = r.Header.Get(header)
+		}
+		if key == "" && c.Query != "" {
+			key = r.URL.Query().Get(c.Query)
+		}
+		if key == "" {
+			return nil, false, nil
+		}
+		p, err := c.Verify(r.Context(), key)
+		return p, true, err
+	})
+}
+
+// RequireAuthentication answers with 401 the requests that were not
+// authenticated by the previous middleware, including the challenges of
+// all of them. It is used after optional authentication middleware:
+//
+//	ion.Chain{
+//		middleware.BasicAuthConfig{Verify: basic, Optional: true}.Middleware,
+//		middleware.BearerAuthConfig{Verify: bearer, Optional: true}.Middleware,
+//		middleware.RequireAuthentication,
+//	}
+func RequireAuthentication(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := PrincipalFrom(r.Context()); !ok {
+			unauthorized(w, r, "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
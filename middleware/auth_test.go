@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/estebarb/ion"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func whoami(w http.ResponseWriter, r *http.Request) {
+	p, ok := PrincipalFrom(r.Context())
+	if !ok {
+		fmt.Fprint(w, "anonymous")
+		return
+	}
+	fmt.Fprintf(w, "%s:%s", p.Scheme, p.Subject)
+}
+
+func TestBasicAuth(t *testing.T) {
+	h := BasicAuthConfig{
+		Realm:  "test",
+		Verify: StaticBasic(map[string]string{"frank": "secret"}),
+	}.Middleware(http.HandlerFunc(whoami))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("frank", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Body.String() != "Basic:frank" {
+		t.Errorf("Expected frank to be authenticated, got %d %q", w.Code, w.Body.String())
+	}
+
+	req.SetBasicAuth("frank", "wrong")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", w.Code)
+	}
+	if w.Header().Get("WWW-Authenticate") != `Basic realm="test", charset="UTF-8"` {
+		t.Errorf("Unexpected challenge %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without credentials, got %d", w.Code)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	h := BearerAuthConfig{
+		Realm:  "api",
+		Verify: StaticTokens("Bearer", map[string]string{"t0k3n": "service"}),
+	}.Middleware(http.HandlerFunc(whoami))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer t0k3n")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Body.String() != "Bearer:service" {
+		t.Errorf("Expected service to be authenticated, got %d %q", w.Code, w.Body.String())
+	}
+
+	req.Header.Set("Authorization", "Bearer other")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("WWW-Authenticate") != `Bearer realm="api", error="invalid_token"` {
+		t.Errorf("Unexpected challenge %v", w.Header())
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	h := APIKeyConfig{
+		Query:  "api_key",
+		Header: "X-Key",
+		Verify: StaticTokens("APIKey", map[string]string{"k1": "client"}),
+	}.Middleware(http.HandlerFunc(whoami))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?api_key=k1", nil))
+	if w.Body.String() != "APIKey:client" {
+		t.Errorf("Expected client to be authenticated, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRequireAuthentication(t *testing.T) {
+	h := ion.Chain{
+		BasicAuthConfig{Realm: "test", Verify: StaticBasic(map[string]string{"frank": "secret"}), Optional: true}.Middleware,
+		BearerAuthConfig{Realm: "api", Verify: StaticTokens("Bearer", map[string]string{"t0k3n": "service"}), Optional: true}.Middleware,
+		RequireAuthentication,
+	}.Then(http.HandlerFunc(whoami))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer t0k3n")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Body.String() != "Bearer:service" {
+		t.Errorf("Expected service to be authenticated, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", w.Code)
+	}
+	if len(w.Header()["Www-Authenticate"]) != 2 {
+		t.Errorf("Expected both challenges, got %v", w.Header())
+	}
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"github.com/estebarb/ion"
+	"net/http"
+)
+
+// unauthenticatedError wraps ion.ErrUnauthenticated with the challenges
+// recorded by the optional authentication middleware
+type unauthenticatedError struct {
+	challenges []string
+}
+
+func (e unauthenticatedError) Error() string {
+	return ion.ErrUnauthenticated.Error()
+}
+
+func (e unauthenticatedError) Unwrap() error {
+	return ion.ErrUnauthenticated
+}
+
+func (e unauthenticatedError) Challenges() []string {
+	return e.challenges
+}
+
+func unauthenticated(r *http.Request) error {
+	challenges, _ := r.Context().Value(challengesKey{}).([]string)
+	return unauthenticatedError{challenges: challenges}
+}
+
+// AllowIf returns an ion.Policy that allows the requests of authenticated
+// clients for which allow returns true. Anonymous requests are denied with
+// 401, and the rest with 403.
+//
+// As the policies of routes run after the path arguments are captured,
+// they can be used to check ownership:
+//
+//	r.Put("/users/:id", h).Authorize(middleware.AllowIf(
+//		func(r *http.Request, p *middleware.Principal) bool {
+//			return r.Context().Value("id") == p.Subject
+//		}))
+func AllowIf(allow func(r *http.Request, p *Principal) bool) ion.Policy {
+	return func(r *http.Request) error {
+		p, ok := PrincipalFrom(r.Context())
+		if !ok {
+			return unauthenticated(r)
+		}
+		if !allow(r, p) {
+			return ion.ErrForbidden
+		}
+		return nil
+	}
+}
+
+// Authenticated is an ion.Policy that allows any authenticated client
+func Authenticated(r *http.Request) error {
+	if _, ok := PrincipalFrom(r.Context()); !ok {
+		return unauthenticated(r)
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRoles returns an ion.Policy that allows the clients that have
+// all the given roles
+func RequireRoles(roles ...string) ion.Policy {
+	return AllowIf(func(r *http.Request, p *Principal) bool {
+		for _, role := range roles {
+			if !contains(p.Roles, role) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// RequireAnyRole returns an ion.Policy that allows the clients that have
+// at least one of the given roles
+func RequireAnyRole(roles ...string) ion.Policy {
+	return AllowIf(func(r *http.Request, p *Principal) bool {
+		for _, role := range roles {
+			if contains(p.Roles, role) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// RequireScopes returns an ion.Policy that allows the clients that were
+// granted all the given scopes
+func RequireScopes(scopes ...string) ion.Policy {
+	return AllowIf(func(r *http.Request, p *Principal) bool {
+		for _, scope := range scopes {
+			if !contains(p.Scopes, scope) {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"github.com/estebarb/ion"
+	"github.com/estebarb/ion/components/router"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorize_Route(t *testing.T) {
+	tokens := map[string]*Principal{
+		"frank": {Subject: "frank", Scopes: []string{"users:write"}},
+		"admin": {Subject: "admin", Roles: []string{"admin"}, Scopes: []string{"users:write"}},
+	}
+	auth := BearerAuthConfig{
+		Realm:    "api",
+		Optional: true,
+		Verify: func(ctx context.Context, token string) (*Principal, error) {
+			if p, ok := tokens[token]; ok {
+				return p, nil
+			}
+			return nil, ErrInvalidCredentials
+		},
+	}
+	r := router.New()
+	r.PutFunc("/users/:id", dummyHandler).Authorize(
+		RequireScopes("users:write"),
+		AllowIf(func(r *http.Request, p *Principal) bool {
+			return contains(p.Roles, "admin") || r.Context().Value("id") == p.Subject
+		}))
+	h := auth.Middleware(r)
+
+	cases := []struct {
+		token, path string
+		status      int
+	}{
+		{"", "/users/frank", http.StatusUnauthorized},
+		{"frank", "/users/frank", http.StatusOK},
+		{"frank", "/users/admin", http.StatusForbidden},
+		{"admin", "/users/frank", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPut, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != c.status {
+			t.Errorf("%s %s: expected %d, got %d", c.token, c.path, c.status, w.Code)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Bearer realm="api"` {
+			t.Errorf("Expected a challenge, got %v", w.Header())
+		}
+	}
+}
+
+func TestAuthorize_WrappedChallenges(t *testing.T) {
+	auth := BearerAuthConfig{
+		Realm:    "api",
+		Optional: true,
+		Verify: func(ctx context.Context, token string) (*Principal, error) {
+			return nil, ErrInvalidCredentials
+		},
+	}
+	h := auth.Middleware(ion.Authorize(func(r *http.Request) error {
+		if err := Authenticated(r); err != nil {
+			return fmt.Errorf("reading the report: %w", err)
+		}
+		return nil
+	})(http.HandlerFunc(dummyHandler)))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer realm="api"` {
+		t.Errorf("Expected 401 with the challenge, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestAuthorize_Endpoint(t *testing.T) {
+	routes := ion.Routes{
+		"/:name": {
+			HttpHandler: http.HandlerFunc(dummyHandler),
+			Authorize:   []ion.Policy{RequireAnyRole("admin", "editor")},
+		},
+	}
+	h := routes.Build()
+
+	for roles, status := range map[string]int{
+		"editor": http.StatusOK,
+		"guest":  http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/page", nil)
+		ctx := WithPrincipal(req.Context(), &Principal{Subject: "x", Roles: []string{roles}})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req.WithContext(ctx))
+		if w.Code != status {
+			t.Errorf("%s: expected %d, got %d", roles, status, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("Expected a JSON 401 for anonymous requests, got %d %v", w.Code, w.Header())
+	}
+}
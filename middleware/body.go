@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/estebarb/ion"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Default limits used by BodyConfig
+const (
+	DefaultMaxBodyBytes  = 10 << 20
+	DefaultMaxMemoryBody = 1 << 20
+)
+
+// BodyConfig configures the parsing of request bodies
+type BodyConfig struct {
+	// MaxBytes limits the size of the body, including the files of
+	// multipart requests. DefaultMaxBodyBytes is used if zero.
+	MaxBytes int64
+	// MaxMemory limits the bytes of multipart requests kept in memory,
+	// the rest of the files are stored on disk.
+	// DefaultMaxMemoryBody is used if zero.
+	MaxMemory int64
+	// PassThrough lists media types that are not parsed but allowed to
+	// reach the handler, for example "application/octet-stream".
+	PassThrough []string
+}
+
+type jsonBodyKey struct{}
+
+// JSONBody returns the body of a JSON request parsed by BodyConfig
+func JSONBody(ctx context.Context) (json.RawMessage, bool) {
+	body, ok := ctx.Value(jsonBodyKey{}).(json.RawMessage)
+	return body, ok
+}
+
+// DecodeJSONBody unmarshals the body of a JSON request parsed by
+// BodyConfig into v.
+func DecodeJSONBody(ctx context.Context, v interface{}) error {
+	body, ok := JSONBody(ctx)
+	if !ok {
+		return errors.New("middleware: request has no JSON body")
+	}
+	return json.Unmarshal(body, v)
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// Middleware parses the body of the requests before calling the wrapped
+// handler. URL encoded and multipart forms are available through the
+// request Form, PostForm and MultipartForm, and JSON documents through
+// JSONBody. Malformed bodies are answered with 400, bodies larger than
+// MaxBytes with 413 and unsupported media types with 415.
+func (c BodyConfig) Middleware(next http.Handler) http.Handler {
+	maxBytes := c.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultMaxBodyBytes
+	}
+	maxMemory := c.MaxMemory
+	if maxMemory == 0 {
+		maxMemory = DefaultMaxMemoryBody
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBytes {
+			ion.WriteError(w, r, http.StatusRequestEntityTooLarge, "request body too large", "")
+			return
+		}
+		if !hasBody(r) {
+			if err := r.ParseForm(); err != nil {
+				ion.WriteError(w, r, http.StatusBadRequest, err.Error(), "")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			ion.WriteError(w, r, http.StatusUnsupportedMediaType, "invalid Content-Type", "")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+		switch {
+		case mediaType == "application/x-www-form-urlencoded":
+			err = r.ParseForm()
+		case mediaType == "multipart/form-data":
+			err = r.ParseMultipartForm(maxMemory)
+			if r.MultipartForm != nil {
+				defer r.MultipartForm.RemoveAll()
+			}
+		case isJSON(mediaType):
+			var body []byte
+			body, err = ioutil.ReadAll(r.Body)
+			if err == nil && !json.Valid(body) {
+				err = errors.New("malformed JSON body")
+			}
+			if err == nil {
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+				ctx := context.WithValue(r.Context(), jsonBodyKey{}, json.RawMessage(body))
+				r = r.WithContext(ctx)
+				err = r.ParseForm()
+			}
+		case c.passThrough(mediaType):
+			err = r.ParseForm()
+		default:
+			ion.WriteError(w, r, http.StatusUnsupportedMediaType,
+				"unsupported media type "+mediaType, "")
+			return
+		}
+
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				ion.WriteError(w, r, http.StatusRequestEntityTooLarge, "request body too large", "")
+			} else {
+				ion.WriteError(w, r, http.StatusBadRequest, err.Error(), "")
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (c BodyConfig) passThrough(mediaType string) bool {
+	for _, t := range c.PassThrough {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
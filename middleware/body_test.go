@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postBody(h http.Handler, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestBodyConfig_Form(t *testing.T) {
+	var value string
+	h := BodyConfig{}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value = r.PostForm.Get("name")
+	}))
+	w := postBody(h, "application/x-www-form-urlencoded", "name=ion")
+	if w.Code != http.StatusOK || value != "ion" {
+		t.Errorf("Expected name=ion, got %d %q", w.Code, value)
+	}
+
+	w = postBody(h, "application/x-www-form-urlencoded", "name=%zz")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed form, got %d", w.Code)
+	}
+}
+
+func TestBodyConfig_JSON(t *testing.T) {
+	var value struct{ Name string }
+	h := BodyConfig{}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := DecodeJSONBody(r.Context(), &value); err != nil {
+			t.Error(err)
+		}
+	}))
+	w := postBody(h, "application/json; charset=utf-8", `{"name":"ion"}`)
+	if w.Code != http.StatusOK || value.Name != "ion" {
+		t.Errorf("Expected name ion, got %d %q", w.Code, value.Name)
+	}
+
+	w = postBody(h, "application/json", `{"name":`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for malformed JSON, got %d", w.Code)
+	}
+}
+
+func TestBodyConfig_Multipart(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("name", "ion")
+	fw, _ := mw.CreateFormFile("file", "hello.txt")
+	fw.Write([]byte("hello"))
+	mw.Close()
+
+	var name, file string
+	h := BodyConfig{MaxMemory: 1}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name = r.FormValue("name")
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var b bytes.Buffer
+		b.ReadFrom(f)
+		file = b.String()
+	}))
+	w := postBody(h, mw.FormDataContentType(), buf.String())
+	if w.Code != http.StatusOK || name != "ion" || file != "hello" {
+		t.Errorf("Unexpected result %d %q %q", w.Code, name, file)
+	}
+}
+
+func TestBodyConfig_Limits(t *testing.T) {
+	h := BodyConfig{MaxBytes: 4}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := postBody(h, "application/json", `"too large"`)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("name=large"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a streamed body, got %d", w.Code)
+	}
+
+	w = postBody(h, "text/csv", "a,b")
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415, got %d", w.Code)
+	}
+}
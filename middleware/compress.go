@@ -0,0 +1,299 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"github.com/estebarb/ion"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Encoding describes a content coding that can be used to compress
+// responses, for example brotli or zstd from third party packages.
+type Encoding struct {
+	// Name is the token used in Accept-Encoding and Content-Encoding
+	Name string
+	// NewWriter returns a writer that compresses into w. If the returned
+	// writer has a Flush() error method it is used to flush streams.
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+// DefaultCompressMinSize is the minimum size of the responses compressed
+// by CompressConfig, if none is given
+const DefaultCompressMinSize = 1024
+
+// DefaultCompressTypes are the media types compressed by CompressConfig
+// if none are given
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/javascript",
+	"application/xml",
+	"application/*+xml",
+	"image/svg+xml",
+}
+
+// CompressConfig configures the compression of responses
+type CompressConfig struct {
+	// Level is the compression level used by gzip and deflate.
+	// Zero means the default level.
+	Level int
+	// MinSize is the minimum number of bytes a response must have to be
+	// compressed. DefaultCompressMinSize is used if zero. Streamed
+	// responses are compressed regardless of their size.
+	MinSize int
+	// ContentTypes lists the media types that are compressed. Entries may
+	// use a wildcard, like "text/*" or "application/*+json".
+	// DefaultCompressTypes is used if nil.
+	ContentTypes []string
+	// Encodings are additional encodings, preferred over gzip and deflate
+	// in the given order when the client accepts them equally.
+	Encodings []Encoding
+}
+
+// Compress compresses responses with gzip or deflate, using the default
+// CompressConfig
+func Compress(next http.Handler) http.Handler {
+	return CompressConfig{}.Middleware(next)
+}
+
+func (c CompressConfig) encodings() []Encoding {
+	level := c.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	encodings := append([]Encoding{}, c.Encodings...)
+	return append(encodings,
+		Encoding{Name: "gzip", NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		}},
+		Encoding{Name: "deflate", NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		}})
+}
+
+// Negotiate returns the name of the encoding that would be used for the
+// response to r, or an empty string if it would not be compressed.
+func (c CompressConfig) Negotiate(r *http.Request) string {
+	encoding := c.negotiate(r)
+	if encoding == nil {
+		return ""
+	}
+	return encoding.Name
+}
+
+func (c CompressConfig) negotiate(r *http.Request) *Encoding {
+	header := r.Header.Get("Accept-Encoding")
+	if header == "" || r.Method == http.MethodHead {
+		return nil
+	}
+	specs := ion.ParseAccept(header)
+	encodings := c.encodings()
+	var best *Encoding
+	bestQ := 0.0
+	for i := range encodings {
+		q := -1.0
+		for _, spec := range specs {
+			if spec.Value == encodings[i].Name {
+				q = spec.Q
+				break
+			}
+			if spec.Value == "*" && q < 0 {
+				q = spec.Q
+			}
+		}
+		if q > bestQ {
+			best, bestQ = &encodings[i], q
+		}
+	}
+	return best
+}
+
+func (c CompressConfig) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	types := c.ContentTypes
+	if types == nil {
+		types = DefaultCompressTypes
+	}
+	for _, t := range types {
+		if t == mediaType {
+			return true
+		}
+		if i := strings.IndexByte(t, '*'); i >= 0 &&
+			strings.HasPrefix(mediaType, t[:i]) &&
+			strings.HasSuffix(mediaType, t[i+1:]) &&
+			len(mediaType) >= len(t)-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware compresses the responses of the wrapped handler using the
+// encoding negotiated with the Accept-Encoding header. Responses smaller than
+// MinSize, of media types not listed in ContentTypes or already encoded are
+// sent uncompressed.
+func (c CompressConfig) Middleware(next http.Handler) http.Handler {
+	minSize := c.MinSize
+	if minSize == 0 {
+		minSize = DefaultCompressMinSize
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := c.negotiate(r)
+		if encoding == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{
+			ResponseWriter: w,
+			config:         c,
+			encoding:       encoding,
+			minSize:        minSize,
+		}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// compressWriter buffers the start of the response until it can decide
+// whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	config   CompressConfig
+	encoding *Encoding
+	minSize  int
+
+	status  int
+	decided bool
+	buf     bytes.Buffer
+	encoder io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	if status < 200 {
+		// Informational responses are sent as is
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.buf.Write(b)
+		if w.buf.Len() < w.minSize {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide writes the headers, choosing whether to compress, and then sends
+// the buffered data
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && w.buf.Len() > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+	}
+	if compress &&
+		h.Get("Content-Encoding") == "" &&
+		w.status != http.StatusNoContent &&
+		w.status != http.StatusNotModified &&
+		w.status != http.StatusPartialContent &&
+		w.config.compressible(h.Get("Content-Type")) {
+		encoder, err := w.encoding.NewWriter(w.ResponseWriter)
+		if err == nil {
+			w.encoder = encoder
+			h.Set("Content-Encoding", w.encoding.Name)
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			if etag := h.Get("ETag"); strings.HasPrefix(etag, "\"") {
+				h.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+	if w.encoder == nil && w.buf.Len() > 0 && h.Get("Content-Length") == "" {
+		h.Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// Flush sends the buffered data to the client. Responses that are flushed
+// before reaching MinSize are considered streams and are compressed if
+// their media type allows it.
+func (w *compressWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.decide(true)
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response
+func (w *compressWriter) Close() error {
+	if w.status == 0 && !w.decided {
+		// Nothing was written, the default response is sent
+		return nil
+	}
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.encoder != nil {
+		return w.encoder.Close()
+	}
+	return nil
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
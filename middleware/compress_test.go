@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var largeText = strings.Repeat("hello world ", 200)
+
+func compressRequest(h http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func textHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(body))
+	})
+}
+
+func TestCompress_Negotiation(t *testing.T) {
+	h := Compress(textHandler(largeText))
+
+	w := compressRequest(h, "deflate, gzip;q=0.5")
+	if w.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("Expecting deflate, got %v", w.Header())
+	}
+	buf, _ := ioutil.ReadAll(flate.NewReader(w.Body))
+	if string(buf) != largeText {
+		t.Errorf("Unexpected body %q", buf)
+	}
+
+	w = compressRequest(h, "gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expecting gzip, got %v", w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ = ioutil.ReadAll(zr)
+	if string(buf) != largeText {
+		t.Errorf("Unexpected body %q", buf)
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Expecting Vary: Accept-Encoding, got %v", w.Header())
+	}
+
+	w = compressRequest(h, "gzip;q=0, br")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != largeText {
+		t.Errorf("Expecting an uncompressed response, got %v", w.Header())
+	}
+}
+
+func TestCompress_Skipped(t *testing.T) {
+	w := compressRequest(Compress(textHandler("small")), "gzip")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "small" {
+		t.Errorf("Small responses must not be compressed, got %v", w.Header())
+	}
+	if w.Header().Get("Content-Length") != "5" {
+		t.Errorf("Expecting Content-Length: 5, got %v", w.Header())
+	}
+
+	h := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(largeText))
+	}))
+	w = compressRequest(h, "gzip")
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Images must not be compressed, got %v", w.Header())
+	}
+}
+
+func TestCompress_Encodings(t *testing.T) {
+	identity := Encoding{
+		Name: "x-test",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+	}
+	h := CompressConfig{
+		MinSize:   1,
+		Encodings: []Encoding{identity},
+	}.Middleware(textHandler("hi"))
+	w := compressRequest(h, "gzip, x-test")
+	if w.Header().Get("Content-Encoding") != "x-test" || w.Body.String() != "hi" {
+		t.Errorf("Expecting the custom encoding, got %v", w.Header())
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestCompress_Flush(t *testing.T) {
+	h := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: 2\n\n"))
+	}))
+	w := compressRequest(h, "gzip")
+	if !w.Flushed {
+		t.Error("Expecting the response to be flushed")
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadAll(zr)
+	if string(buf) != "data: 1\n\ndata: 2\n\n" {
+		t.Errorf("Unexpected body %q", buf)
+	}
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MethodLister is implemented by routers that can report which methods
+// are registered for a path, like router.Router.
+type MethodLister interface {
+	MethodsFor(path string) []string
+}
+
+// CORSConfig configures Cross-Origin Resource Sharing
+type CORSConfig struct {
+	// AllowedOrigins lists the allowed origins. Each entry may be an exact
+	// origin ("https://example.com"), a subdomain wildcard
+	// ("https://*.example.com") or "*" to allow any origin.
+	AllowedOrigins []string
+	// AllowOriginFunc, if not nil, is consulted for the origins that do not
+	// match AllowedOrigins.
+	AllowOriginFunc func(origin string, r *http.Request) bool
+	// Methods, if not nil, reports the methods allowed for a path in
+	// preflight requests. Usually it is the router.Router wrapped by
+	// the middleware.
+	Methods MethodLister
+	// AllowedMethods lists the methods allowed in preflight requests when
+	// Methods is nil. GET, HEAD and POST are used if empty.
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers allowed in preflight
+	// requests. If empty the headers requested by the client are allowed.
+	AllowedHeaders []string
+	// ExposedHeaders lists the response headers that the client can read
+	ExposedHeaders []string
+	// AllowCredentials allows the requests to include cookies and
+	// HTTP authentication
+	AllowCredentials bool
+	// MaxAge indicates how long the result of a preflight request can be
+	// cached. It is not sent if zero.
+	MaxAge time.Duration
+}
+
+// Middleware adds the CORS headers to the responses of requests from
+// allowed origins, and answers preflight requests without calling the
+// wrapped handler. If the middleware wraps a router.Router then the router
+// should be given as Methods, so that preflight requests report the methods
+// registered for the requested path.
+func (c CORSConfig) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		// The responses depend on these headers even when they are
+		// missing, so that caches do not reuse them for other origins
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if r.Method == http.MethodOptions {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		preflight := r.Method == http.MethodOptions &&
+			r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !c.allowOrigin(origin, r) {
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+			} else {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
+		if c.allowAnyOrigin() && !c.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(c.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		methods := c.methods(r)
+		if len(methods) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(c.AllowedHeaders) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			h.Set("Access-Control-Allow-Headers", requested)
+		}
+		if c.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (c CORSConfig) allowAnyOrigin() bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CORSConfig) allowOrigin(origin string, r *http.Request) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if i := strings.Index(allowed, "://*."); i >= 0 {
+			prefix := allowed[:i+3]
+			suffix := allowed[i+4:]
+			if strings.HasPrefix(origin, prefix) &&
+				strings.HasSuffix(origin, suffix) &&
+				len(origin) > len(prefix)+len(suffix) {
+				return true
+			}
+		}
+	}
+	return c.AllowOriginFunc != nil && c.AllowOriginFunc(origin, r)
+}
+
+func (c CORSConfig) methods(r *http.Request) []string {
+	if c.Methods != nil {
+		return c.Methods.MethodsFor(r.URL.Path)
+	}
+	if len(c.AllowedMethods) > 0 {
+		return c.AllowedMethods
+	}
+	return []string{http.MethodGet, http.MethodHead, http.MethodPost}
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"github.com/estebarb/ion/components/router"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func corsRequest(h http.Handler, method, path, origin string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Origin", origin)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSConfig_Preflight(t *testing.T) {
+	r := router.New()
+	r.GetFunc("/users/:id", dummyHandler)
+	r.PutFunc("/users/:id", dummyHandler)
+	h := CORSConfig{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		Methods:          r,
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}.Middleware(r)
+
+	w := corsRequest(h, http.MethodOptions, "/users/1", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "Content-Type",
+	})
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, PUT",
+		"Access-Control-Allow-Headers":     "Content-Type",
+		"Access-Control-Max-Age":           "3600",
+	}
+	for k, v := range expected {
+		if w.Header().Get(k) != v {
+			t.Errorf("Expected %s: %s, got %q", k, v, w.Header().Get(k))
+		}
+	}
+
+	w = corsRequest(h, http.MethodOptions, "/users/1", "https://example.com", map[string]string{
+		"Access-Control-Request-Method": "PUT",
+	})
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("The bare domain must not match a subdomain wildcard")
+	}
+
+	w = corsRequest(h, http.MethodOptions, "/missing", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method": "GET",
+	})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a path without routes, got %d", w.Code)
+	}
+}
+
+func TestCORSConfig_Simple(t *testing.T) {
+	h := CORSConfig{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowOriginFunc: func(origin string, r *http.Request) bool {
+			return strings.HasSuffix(origin, ".test")
+		},
+		ExposedHeaders: []string{"X-Request-Id"},
+	}.Middleware(http.HandlerFunc(dummyHandler))
+
+	w := corsRequest(h, http.MethodGet, "/", "https://example.com", nil)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Errorf("Expected the origin to be allowed, got %v", w.Header())
+	}
+	if w.Header().Get("Access-Control-Expose-Headers") != "X-Request-Id" {
+		t.Errorf("Expected exposed headers, got %v", w.Header())
+	}
+	if w.Body.String() != "hello" {
+		t.Errorf("Expected the handler to be called, got %q", w.Body.String())
+	}
+
+	w = corsRequest(h, http.MethodGet, "/", "http://local.test", nil)
+	if w.Header().Get("Access-Control-Allow-Origin") != "http://local.test" {
+		t.Errorf("Expected the predicate to allow the origin, got %v", w.Header())
+	}
+
+	w = corsRequest(h, http.MethodGet, "/", "https://evil.com", nil)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected the origin to be rejected, got %v", w.Header())
+	}
+}
+
+func TestCORSConfig_VaryWithoutOrigin(t *testing.T) {
+	h := CORSConfig{AllowedOrigins: []string{"https://example.com"}}.Middleware(http.HandlerFunc(dummyHandler))
+
+	w := corsRequest(h, http.MethodGet, "/", "", nil)
+	if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Origin" {
+		t.Errorf("Expected Vary: Origin without an Origin, got %v", vary)
+	}
+	w = corsRequest(h, http.MethodOptions, "/", "", nil)
+	if vary := strings.Join(w.Header().Values("Vary"), ", "); vary !=
+		"Origin, Access-Control-Request-Method, Access-Control-Request-Headers" {
+		t.Errorf("Expected the preflight headers in Vary for OPTIONS, got %q", vary)
+	}
+}
+
+func TestCORSConfig_Wildcard(t *testing.T) {
+	h := CORSConfig{AllowedOrigins: []string{"*"}}.Middleware(http.HandlerFunc(dummyHandler))
+	w := corsRequest(h, http.MethodGet, "/", "https://any.com", nil)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("Expected *, got %v", w.Header())
+	}
+}
@@ -0,0 +1,391 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/estebarb/ion"
+	"github.com/estebarb/ion/middleware/sessions"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Reasons of the CSRF validation failures, available to the ErrorHandler
+// through CSRFFailure
+var (
+	ErrCSRFOrigin       = errors.New("middleware: CSRF origin not allowed")
+	ErrCSRFMissingToken = errors.New("middleware: CSRF token missing")
+	ErrCSRFInvalidToken = errors.New("middleware: CSRF token invalid")
+)
+
+// csrfSecretSize is the size in bytes of the CSRF secrets
+const csrfSecretSize = 32
+
+// csrfSessionKey is the key of the CSRF secret in the session values
+const csrfSessionKey = "_csrf"
+
+// CSRFConfig configures the protection against Cross-Site Request Forgery
+type CSRFConfig struct {
+	// Session keeps the token in the session (synchronizer token pattern).
+	// It requires the sessions middleware to be applied before. Otherwise
+	// the token is kept in a cookie, that must be sent back in the header
+	// or form field (signed double submit cookie pattern).
+	Session bool
+	// Cookie is the name of the cookie that keeps the token when Session is
+	// false. "_csrf" is used if empty.
+	Cookie string
+	// CookiePath and CookieDomain of the token cookie. CookiePath defaults
+	// to "/".
+	CookiePath   string
+	CookieDomain string
+	// Secure restricts the token cookie to HTTPS
+	Secure bool
+	// Keys sign the token cookie with HMAC-SHA256, so that it can not be
+	// planted by sibling subdomains or insecure origins. The first key
+	// signs the new cookies, and all of them are accepted to allow
+	// rotating the keys. If empty, a random key shared by all the
+	// middleware of the process is generated, so the cookies are only
+	// valid until it restarts.
+	Keys [][]byte
+	// Header containing the token. "X-CSRF-Token" is used if empty.
+	Header string
+	// FormField containing the token. "csrf_token" is used if empty.
+	FormField string
+	// TrustedOrigins lists origins other than the one of the request that
+	// may send unsafe requests, like "https://admin.example.com"
+	TrustedOrigins []string
+	// ExemptPaths lists paths that are not protected, like the ones of APIs
+	// authenticated without cookies. Paths ending with "/" exempt all the
+	// paths they prefix.
+	ExemptPaths []string
+	// Exempt, if not nil, reports whether a request is not protected
+	Exempt func(r *http.Request) bool
+	// ErrorHandler, if not nil, handles the requests that fail the
+	// validation. The reason is available through CSRFFailure. By default
+	// they are answered with 403.
+	ErrorHandler http.Handler
+}
+
+type csrfKey struct{}
+
+type csrfFailureKey struct{}
+
+// csrfState gives access to the secret of a request, creating it the
+// first time it is needed
+type csrfState struct {
+	field  string
+	l      sync.Mutex
+	secret []byte
+	err    error
+	create func() ([]byte, error)
+}
+
+func (s *csrfState) get() ([]byte, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.secret == nil && s.err == nil {
+		s.secret, s.err = s.create()
+	}
+	return s.secret, s.err
+}
+
+func newCSRFSecret() ([]byte, error) {
+	secret := make([]byte, csrfSecretSize)
+	_, err := rand.Read(secret)
+	return secret, err
+}
+
+// The random key of the middleware without Keys, generated once so that
+// the cookies set by one middleware are accepted by the others
+var (
+	processKeyOnce sync.Once
+	processKey     []byte
+	processKeyErr  error
+)
+
+func processCSRFKey() ([]byte, error) {
+	processKeyOnce.Do(func() {
+		processKey, processKeyErr = newCSRFSecret()
+	})
+	return processKey, processKeyErr
+}
+
+var csrfEncoding = base64.RawURLEncoding
+
+func csrfMAC(key, secret []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("csrf\x00"))
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// signCSRF returns the value of the token cookie, with the secret and
+// its signature
+func signCSRF(key, secret []byte) string {
+	return csrfEncoding.EncodeToString(secret) + "." + csrfEncoding.EncodeToString(csrfMAC(key, secret))
+}
+
+// verifyCSRF returns the secret of a token cookie, or nil if it is not
+// signed by any of the keys
+func verifyCSRF(keys [][]byte, value string) []byte {
+	i := strings.IndexByte(value, '.')
+	if i < 0 {
+		return nil
+	}
+	secret, err := csrfEncoding.DecodeString(value[:i])
+	if err != nil || len(secret) != csrfSecretSize {
+		return nil
+	}
+	signature, err := csrfEncoding.DecodeString(value[i+1:])
+	if err != nil {
+		return nil
+	}
+	for _, key := range keys {
+		if hmac.Equal(signature, csrfMAC(key, secret)) {
+			return secret
+		}
+	}
+	return nil
+}
+
+// maskCSRF returns the secret xored with a random pad, preceded by the
+// pad, so that the token changes on every response (mitigating BREACH)
+func maskCSRF(secret []byte) (string, error) {
+	token := make([]byte, 2*len(secret))
+	if _, err := rand.Read(token[:len(secret)]); err != nil {
+		return "", err
+	}
+	for i, b := range secret {
+		token[len(secret)+i] = b ^ token[i]
+	}
+	return csrfEncoding.EncodeToString(token), nil
+}
+
+func unmaskCSRF(token string) []byte {
+	b, err := csrfEncoding.DecodeString(token)
+	if err != nil || len(b) != 2*csrfSecretSize {
+		return nil
+	}
+	secret := make([]byte, csrfSecretSize)
+	for i := range secret {
+		secret[i] = b[i] ^ b[csrfSecretSize+i]
+	}
+	return secret
+}
+
+// CSRFToken returns the token that must be sent in the unsafe requests,
+// in the form field or header configured. It returns an empty string if
+// the CSRF middleware was not applied.
+func CSRFToken(r *http.Request) string {
+	state, ok := r.Context().Value(csrfKey{}).(*csrfState)
+	if !ok {
+		return ""
+	}
+	secret, err := state.get()
+	if err != nil {
+		ion.LoggerFrom(r.Context()).Error("csrf token", "error", err.Error())
+		return ""
+	}
+	token, err := maskCSRF(secret)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// CSRFField returns a hidden input with the token, to be included in
+// the forms of templates. It is usually exposed as a template function:
+//
+//	tmpl.Funcs(template.FuncMap{"csrfField": func() template.HTML {
+//		return middleware.CSRFField(r)
+//	}})
+func CSRFField(r *http.Request) template.HTML {
+	field := "csrf_token"
+	if state, ok := r.Context().Value(csrfKey{}).(*csrfState); ok && state.field != "" {
+		field = state.field
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(field) +
+		`" value="` + template.HTMLEscapeString(CSRFToken(r)) + `">`)
+}
+
+// CSRFFailure returns the reason why the CSRF validation of the request
+// failed, in the ErrorHandler
+func CSRFFailure(ctx context.Context) error {
+	err, _ := ctx.Value(csrfFailureKey{}).(error)
+	return err
+}
+
+func (c CSRFConfig) exempt(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	for _, path := range c.ExemptPaths {
+		if r.URL.Path == path || strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path) {
+			return true
+		}
+	}
+	return c.Exempt != nil && c.Exempt(r)
+}
+
+// checkOrigin verifies that the Origin header, or the Referer if there is
+// no Origin, belongs to the host of the request or a trusted origin. The
+// requests without any of them are allowed, as some clients remove them.
+func (c CSRFConfig) checkOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+		if source == "" {
+			return true
+		}
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	origin := u.Scheme + "://" + u.Host
+	for _, trusted := range c.TrustedOrigins {
+		if strings.EqualFold(origin, trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware protects the requests with unsafe methods against CSRF. They
+// must come from the same origin or a trusted one, according to the Origin
+// or Referer headers, and include the token returned by CSRFToken in the
+// configured header or form field.
+//
+// The requests with safe methods (GET, HEAD, OPTIONS and TRACE) are never
+// validated, so they must not have side effects.
+func (c CSRFConfig) Middleware(next http.Handler) http.Handler {
+	cookieName := c.Cookie
+	if cookieName == "" {
+		cookieName = "_csrf"
+	}
+	header := c.Header
+	if header == "" {
+		header = "X-CSRF-Token"
+	}
+	field := c.FormField
+	if field == "" {
+		field = "csrf_token"
+	}
+	cookiePath := c.CookiePath
+	if cookiePath == "" {
+		cookiePath = "/"
+	}
+	keys := c.Keys
+	if len(keys) == 0 && !c.Session {
+		key, err := processCSRFKey()
+		if err != nil {
+			panic(err)
+		}
+		keys = [][]byte{key}
+	}
+
+	fail := func(w http.ResponseWriter, r *http.Request, reason error) {
+		if c.ErrorHandler != nil {
+			c.ErrorHandler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfFailureKey{}, reason)))
+			return
+		}
+		ion.WriteError(w, r, http.StatusForbidden, "CSRF validation failed", reason.Error())
+	}
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		state := &csrfState{field: field}
+		var stored func() ([]byte, error)
+		if c.Session {
+			stored = func() ([]byte, error) {
+				s, err := sessions.From(r.Context())
+				if err != nil {
+					return nil, err
+				}
+				secret, _ := s.Get(csrfSessionKey).([]byte)
+				return secret, nil
+			}
+			state.create = func() ([]byte, error) {
+				s, err := sessions.From(r.Context())
+				if err != nil {
+					return nil, err
+				}
+				secret, _ := s.Get(csrfSessionKey).([]byte)
+				if len(secret) == csrfSecretSize {
+					return secret, nil
+				}
+				if secret, err = newCSRFSecret(); err == nil {
+					s.Set(csrfSessionKey, secret)
+				}
+				return secret, err
+			}
+		} else {
+			var secret []byte
+			if cookie, err := r.Cookie(cookieName); err == nil {
+				secret = verifyCSRF(keys, cookie.Value)
+			}
+			if secret == nil {
+				// Set the cookie now, as the headers may already be
+				// written when the token is needed
+				var err error
+				if secret, err = newCSRFSecret(); err != nil {
+					ion.WriteError(w, r, http.StatusInternalServerError, "", "")
+					return
+				}
+				http.SetCookie(w, &http.Cookie{
+					Name:     cookieName,
+					Value:    signCSRF(keys[0], secret),
+					Path:     cookiePath,
+					Domain:   c.CookieDomain,
+					Secure:   c.Secure,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+				stored = func() ([]byte, error) { return nil, nil }
+			} else {
+				stored = func() ([]byte, error) { return secret, nil }
+			}
+			state.create = func() ([]byte, error) { return secret, nil }
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfKey{}, state))
+
+		if c.exempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !c.checkOrigin(r) {
+			fail(w, r, ErrCSRFOrigin)
+			return
+		}
+		token := r.Header.Get(header)
+		if token == "" {
+			token = r.PostFormValue(field)
+		}
+		if token == "" {
+			fail(w, r, ErrCSRFMissingToken)
+			return
+		}
+		secret, err := stored()
+		if err != nil {
+			ion.LoggerFrom(r.Context()).Error("csrf token", "error", err.Error())
+			ion.WriteError(w, r, http.StatusInternalServerError, "", "")
+			return
+		}
+		if len(secret) != csrfSecretSize || subtle.ConstantTimeCompare(secret, unmaskCSRF(token)) != 1 {
+			fail(w, r, ErrCSRFInvalidToken)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/estebarb/ion/middleware/sessions"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var csrfValue = regexp.MustCompile(`value="([^"]*)"`)
+
+// csrfForm renders a form on GET and accepts it on POST
+func csrfForm(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		fmt.Fprintf(w, "<form>%s</form>", CSRFField(r))
+		return
+	}
+	fmt.Fprint(w, "accepted")
+}
+
+// csrfFlow loads the form, keeping the cookies, and returns a function
+// that posts it with the given token
+func csrfFlow(t *testing.T, h http.Handler) (string, func(token string, header http.Header) *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/form", nil))
+	m := csrfValue.FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatalf("No token in %q", w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	return m[1], func(token string, header http.Header) *httptest.ResponseRecorder {
+		form := url.Values{"csrf_token": {token}}
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/form", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	handlers := map[string]http.Handler{
+		"cookie": CSRFConfig{}.Middleware(http.HandlerFunc(csrfForm)),
+		"session": sessions.Config{
+			Store: sessions.NewSignedCookieStore([]byte("secret")),
+		}.Middleware(CSRFConfig{Session: true}.Middleware(http.HandlerFunc(csrfForm))),
+	}
+	for name, h := range handlers {
+		token, post := csrfFlow(t, h)
+		if w := post(token, nil); w.Body.String() != "accepted" {
+			t.Errorf("%s: expected the form to be accepted, got %d %q", name, w.Code, w.Body.String())
+		}
+		other, _ := csrfFlow(t, h)
+		if other == token {
+			t.Errorf("%s: expected the tokens to be masked", name)
+		}
+		if w := post(other, nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected the token of another client to be rejected, got %d", name, w.Code)
+		}
+		if w := post("", nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected a missing token to be rejected, got %d", name, w.Code)
+		}
+		header := http.Header{"Origin": {"https://evil.test"}}
+		if w := post(token, header); w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected a foreign origin to be rejected, got %d", name, w.Code)
+		}
+		header = http.Header{"Referer": {"http://example.com/form"}, "X-Csrf-Token": {token}}
+		if w := post("", header); w.Code != http.StatusOK {
+			t.Errorf("%s: expected the token in the header to be accepted, got %d", name, w.Code)
+		}
+	}
+}
+
+func TestCSRF_ExemptAndFailure(t *testing.T) {
+	h := CSRFConfig{
+		ExemptPaths:    []string{"/api/"},
+		TrustedOrigins: []string{"https://admin.example.com"},
+		ErrorHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+			fmt.Fprint(w, CSRFFailure(r.Context()) == ErrCSRFOrigin)
+		}),
+	}.Middleware(http.HandlerFunc(dummyHandler))
+
+	cases := []struct {
+		path, origin string
+		status       int
+		body         string
+	}{
+		{"/api/users", "https://evil.test", http.StatusOK, ""},
+		{"/form", "https://evil.test", http.StatusTeapot, "true"},
+		{"/form", "https://admin.example.com", http.StatusTeapot, "false"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com"+c.path, nil)
+		req.Header.Set("Origin", c.origin)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != c.status || c.body != "" && w.Body.String() != c.body {
+			t.Errorf("%s from %s: unexpected response %d %q", c.path, c.origin, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCSRF_PlantedCookie(t *testing.T) {
+	h := CSRFConfig{Keys: [][]byte{[]byte("new"), []byte("old")}}.Middleware(http.HandlerFunc(csrfForm))
+	secret := make([]byte, csrfSecretSize)
+	token, err := maskCSRF(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for cookie, status := range map[string]int{
+		csrfEncoding.EncodeToString(secret):          http.StatusForbidden,
+		signCSRF([]byte("attacker"), secret):         http.StatusForbidden,
+		signCSRF([]byte("old"), secret):              http.StatusOK,
+		signCSRF([]byte("new"), secret) + "tampered": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/form", nil)
+		req.Header.Set("X-CSRF-Token", token)
+		req.AddCookie(&http.Cookie{Name: "_csrf", Value: cookie})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("Cookie %q: expected %d, got %d", cookie, status, w.Code)
+		}
+	}
+}
+
+func TestCSRF_SeparateMiddleware(t *testing.T) {
+	form := CSRFConfig{}.Middleware(http.HandlerFunc(csrfForm))
+	submit := CSRFConfig{}.Middleware(http.HandlerFunc(csrfForm))
+
+	w := httptest.NewRecorder()
+	form.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/form", nil))
+	m := csrfValue.FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatalf("No token in %q", w.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/form", nil)
+	req.Header.Set("X-CSRF-Token", m[1])
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	submit.ServeHTTP(w, req)
+	if w.Body.String() != "accepted" || len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected the cookie of the other middleware to be accepted, got %d %q %v",
+			w.Code, w.Body.String(), w.Result().Cookies())
+	}
+}
@@ -0,0 +1,238 @@
+package hotcache
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// capture is the ResponseWriter of the executions of the handler. It
+// records the response to store it and, on the request that triggered the
+// execution, writes it to the client as it is generated. That response
+// does not include the ETag generated for the stored entry, as its headers
+// are sent before the body is complete.
+//
+// The responses that are flushed or hijacked are streamed to the client
+// and not stored, like the responses larger than the maximum entry size.
+type capture struct {
+	c    *Config
+	r    *http.Request
+	base string
+	// w is the ResponseWriter of the client, or nil when nobody waits
+	// for the response, like in background refreshes
+	w http.ResponseWriter
+	// holdErrors keeps the 5xx responses from being sent, as the client
+	// is served a stale entry instead
+	holdErrors bool
+
+	header http.Header
+	entry  *Entry
+	body   bytes.Buffer
+	// tee reports whether the response is written to the client, and
+	// sent whether any part of it was
+	tee  bool
+	sent bool
+	// uncacheable reports whether the response was streamed, hijacked or
+	// is too large to be stored
+	uncacheable bool
+	hijacked    bool
+}
+
+func (c *Config) newCapture(w http.ResponseWriter, r *http.Request, base string, holdErrors bool) *capture {
+	return &capture{
+		c:          c,
+		r:          r,
+		base:       base,
+		w:          w,
+		holdErrors: holdErrors,
+		header:     make(http.Header),
+	}
+}
+
+// Header implements http.ResponseWriter
+func (cp *capture) Header() http.Header {
+	return cp.header
+}
+
+// record creates the entry with the headers written by the handler
+func (cp *capture) record(status int) {
+	header := cp.header.Clone()
+	vary, _ := varyHeaders(header)
+	cp.entry = &Entry{
+		Key:    varyKey(cp.base, cp.r, vary),
+		Method: cp.r.Method,
+		Status: status,
+		Header: header,
+		Vary:   vary,
+		Tags:   cp.c.tags(header),
+		Stored: cp.c.now(),
+	}
+	if size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil &&
+		cp.c.maxEntrySize > 0 && size > cp.c.maxEntrySize {
+		cp.uncacheable = true
+	}
+}
+
+// WriteHeader implements http.ResponseWriter. The Cache-Status of the
+// client reports whether the response is expected to be stored, as it is
+// sent before the body is generated.
+func (cp *capture) WriteHeader(status int) {
+	if cp.entry != nil || cp.hijacked {
+		return
+	}
+	if status < 200 {
+		// Informational responses, like 103 Early Hints, are only sent
+		if cp.w != nil {
+			cp.copyHeader(cp.header)
+			cp.w.WriteHeader(status)
+		}
+		return
+	}
+	cp.record(status)
+	cp.tee = cp.w != nil && !(status >= 500 && cp.holdErrors)
+	if !cp.tee {
+		return
+	}
+	cp.copyHeader(cp.entry.Header)
+	_, cacheable := freshness(cp.r, status, cp.entry.Header, cp.entry.Stored, cp.c.timeout)
+	cacheStatus := "fwd=miss"
+	if cacheable && !cp.uncacheable {
+		cacheStatus += "; stored"
+	}
+	h := cp.w.Header()
+	h.Set("Age", "0")
+	h.Set("Cache-Status", cacheName+"; "+cacheStatus)
+	cp.w.WriteHeader(status)
+	cp.sent = true
+}
+
+func (cp *capture) copyHeader(header http.Header) {
+	h := cp.w.Header()
+	for k, v := range header {
+		h[k] = v
+	}
+}
+
+// Write implements http.ResponseWriter. Errors writing to the client are
+// only reported to the handler if the response is not being stored, as
+// it may still be useful to other requests.
+func (cp *capture) Write(b []byte) (int, error) {
+	if cp.entry == nil {
+		if _, ok := cp.header["Content-Type"]; !ok && cp.header.Get("Transfer-Encoding") == "" {
+			cp.header.Set("Content-Type", http.DetectContentType(b))
+		}
+		cp.WriteHeader(http.StatusOK)
+	}
+	if !cp.uncacheable {
+		cp.body.Write(b)
+		if cp.c.maxEntrySize > 0 && int64(cp.body.Len()) > cp.c.maxEntrySize {
+			cp.discard()
+		}
+	}
+	if !cp.tee {
+		return len(b), nil
+	}
+	n, err := cp.w.Write(b)
+	if err != nil {
+		cp.tee = false
+		if cp.uncacheable {
+			return n, err
+		}
+	}
+	return len(b), nil
+}
+
+// discard stops recording the response, as it will not be stored
+func (cp *capture) discard() {
+	cp.uncacheable = true
+	cp.body = bytes.Buffer{}
+}
+
+// FlushError flushes the response to the client. Flushed responses are
+// streamed, so they are not stored.
+func (cp *capture) FlushError() error {
+	if cp.entry == nil {
+		cp.WriteHeader(http.StatusOK)
+	}
+	cp.discard()
+	if !cp.tee {
+		return nil
+	}
+	return http.NewResponseController(cp.w).Flush()
+}
+
+// Flush implements http.Flusher
+func (cp *capture) Flush() {
+	cp.FlushError()
+}
+
+// Hijack implements http.Hijacker. Hijacked responses are not stored.
+func (cp *capture) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if cp.w == nil || cp.entry != nil && !cp.tee {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := http.NewResponseController(cp.w).Hijack()
+	if err == nil {
+		cp.hijacked = true
+		cp.sent = true
+		cp.discard()
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the ResponseWriter of the client, for
+// http.ResponseController
+func (cp *capture) Unwrap() http.ResponseWriter {
+	return cp.w
+}
+
+// finish completes the response after the handler returns, and returns
+// its entry with the trailers set by the handler
+func (cp *capture) finish() *Entry {
+	if cp.entry == nil {
+		if cp.hijacked {
+			cp.record(http.StatusOK)
+		} else {
+			cp.WriteHeader(http.StatusOK)
+		}
+	}
+	e := cp.entry
+	e.Body = cp.body.Bytes()
+
+	var declared []string
+	for _, value := range e.Header.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				declared = append(declared, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	for k, v := range cp.header {
+		name := k
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			name = http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))
+		} else if !contains(declared, k) {
+			continue
+		}
+		if e.Trailer == nil {
+			e.Trailer = make(http.Header)
+		}
+		e.Trailer[name] = v
+		if cp.tee {
+			cp.w.Header()[k] = v
+		}
+	}
+	return e
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
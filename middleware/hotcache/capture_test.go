@@ -0,0 +1,119 @@
+package hotcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_WritesWhileGenerating(t *testing.T) {
+	written, release := make(chan struct{}), make(chan struct{})
+	hc := New(time.Minute)
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello "))
+		close(written)
+		<-release
+		w.Write([]byte("world"))
+	}))
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	<-written
+	if w.Body.String() != "hello " || w.Header().Get("Cache-Status") != "hotcache; fwd=miss; stored" {
+		t.Errorf("Expected the response to be sent while generated, got %q %v", w.Body.String(), w.Header())
+	}
+	close(release)
+	<-done
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Body.String() != "hello world" || !strings.Contains(w.Header().Get("Cache-Status"), "hit") {
+		t.Errorf("Expected the complete response to be cached, got %q %v", w.Body.String(), w.Header())
+	}
+}
+
+func TestConfig_Streaming(t *testing.T) {
+	calls := 0
+	hc := New(time.Minute)
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flushing: %v", err)
+		}
+		w.Write([]byte("data: 2\n\n"))
+	}))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+		if !w.Flushed || w.Body.String() != "data: 1\n\ndata: 2\n\n" {
+			t.Errorf("Expected the response to be streamed, got %v %q", w.Flushed, w.Body.String())
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected streamed responses not to be cached, the handler ran %d times", calls)
+	}
+}
+
+func TestConfig_Trailers(t *testing.T) {
+	hc := New(time.Minute)
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("hello"))
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Count", "1")
+	}))
+
+	for _, status := range []string{"fwd=miss", "hit"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		res := w.Result()
+		if !strings.Contains(res.Header.Get("Cache-Status"), status) {
+			t.Errorf("Expected %q, got %q", status, res.Header.Get("Cache-Status"))
+		}
+		if res.Trailer.Get("X-Checksum") != "abc" || res.Trailer.Get("X-Count") != "1" {
+			t.Errorf("%s: expected the trailers, got %v", status, res.Trailer)
+		}
+	}
+}
+
+func TestConfig_Hijack(t *testing.T) {
+	calls := 0
+	hc := New(time.Minute)
+	ts := httptest.NewServer(hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Hijacking: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi")
+		rw.Flush()
+	})))
+	defer ts.Close()
+
+	for i := 0; i < 2; i++ {
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != "hi" {
+			t.Errorf("Expected the hijacked response, got %q", body)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected hijacked responses not to be cached, the handler ran %d times", calls)
+	}
+}
@@ -0,0 +1,118 @@
+package hotcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_Conditional(t *testing.T) {
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	hc := New(time.Minute)
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tagged":
+			w.Header().Set("ETag", `W/"v1"`)
+			w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("hello world"))
+	}))
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// The first response is sent as it is generated, before the ETag
+	// of the body can be computed
+	get("/", nil)
+	tag := get("/", nil).Header().Get("ETag")
+	if tag == "" || tag[0] != '"' {
+		t.Fatalf("Expected a strong ETag, got %q", tag)
+	}
+	if w := get("/", http.Header{"If-None-Match": {tag}}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected 304, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get("/", http.Header{"If-None-Match": {`"other"`}}); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for another ETag, got %d", w.Code)
+	}
+	w := get("/", http.Header{"Range": {"bytes=6-"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "world" ||
+		w.Header().Get("Content-Range") != "bytes 6-10/11" {
+		t.Errorf("Expected a partial response, got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	w = get("/", http.Header{"Range": {"bytes=6-"}, "If-Range": {`"old"`}})
+	if w.Code != http.StatusOK || w.Body.String() != "hello world" {
+		t.Errorf("Expected the full response for an outdated If-Range, got %d %q", w.Code, w.Body.String())
+	}
+
+	get("/tagged", nil)
+	w = get("/tagged", http.Header{"If-None-Match": {`"v1"`}})
+	if w.Code != http.StatusNotModified || w.Header().Get("ETag") != `W/"v1"` {
+		t.Errorf("Expected 304 with the ETag of the handler, got %d %v", w.Code, w.Header())
+	}
+	since := modified.Add(time.Hour).Format(http.TimeFormat)
+	if w = get("/tagged", http.Header{"If-Modified-Since": {since}}); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for If-Modified-Since, got %d", w.Code)
+	}
+
+	get("/missing", nil)
+	w = get("/missing", http.Header{"Range": {"bytes=0-1"}})
+	if w.Code != http.StatusNotFound || w.Header().Get("ETag") != "" || w.Body.String() != "hello world" {
+		t.Errorf("Expected the cached 404 unchanged, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestConfig_ConditionalHead(t *testing.T) {
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	hc := New(time.Minute)
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", modified, strings.NewReader("hello world"))
+	}))
+
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodHead} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/", nil))
+		if w.Header().Get("Content-Length") != "11" || w.Header().Get("ETag") != `"v1"` {
+			t.Errorf("%s %s: expected the headers of the handler, got %v", method,
+				w.Header().Get("Cache-Status"), w.Header())
+		}
+	}
+}
+
+func TestConfig_ConditionalLarge(t *testing.T) {
+	body := strings.Repeat("a", 20<<10)
+	hc := New(time.Minute)
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body[:len(body)/2]))
+		w.Write([]byte(body[len(body)/2:]))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Body.String() != body {
+		t.Fatalf("Expected the body, got %d bytes", w.Body.Len())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	tag := w.Header().Get("ETag")
+	if tag == "" || w.Body.String() != body {
+		t.Fatalf("Expected the cached body with an ETag, got %d bytes %v", w.Body.Len(), w.Header())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", tag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", w.Code)
+	}
+}
@@ -0,0 +1,161 @@
+package hotcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiskStore is a Store that keeps each entry in a file of a directory,
+// so that the cache survives restarts and can be shared by the processes
+// of a machine. The modification time of the files is set to the time
+// the entries can be discarded, so that Purge does not need to read them.
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore creates a DiskStore that keeps the entries in dir,
+// creating it if needed
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DiskStore{dir: dir}, nil
+}
+
+// tempPrefix is the prefix of the files being written, which are followed
+// by the Unix time they were created at
+const tempPrefix = ".tmp-"
+
+// tempMaxAge is the age after which Purge removes the temporary files,
+// left by the processes that crashed while writing them
+const tempMaxAge = time.Hour
+
+func (s *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".entry")
+}
+
+// Get implements Store
+func (s *DiskStore) Get(ctx context.Context, key string) (*Entry, error) {
+	b, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	e, err := DecodeEntry(b)
+	if err != nil {
+		return nil, err
+	}
+	if e.Key != key {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+// Set implements Store. The file is replaced atomically, so concurrent
+// readers never see partial entries.
+func (s *DiskStore) Set(ctx context.Context, e *Entry) error {
+	b, err := e.Encode()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(s.dir, tempPrefix+strconv.FormatInt(time.Now().Unix(), 10)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	retained := e.Retained()
+	if err := os.Chtimes(tmp.Name(), retained, retained); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(e.Key))
+}
+
+// Delete implements Store
+func (s *DiskStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// DeletePrefix implements Invalidator. It reads all the entries, as the
+// files are named after a hash of the keys.
+func (s *DiskStore) DeletePrefix(ctx context.Context, prefix string) error {
+	return s.deleteIf(ctx, func(e *Entry) bool { return strings.HasPrefix(e.Key, prefix) })
+}
+
+// DeleteTags implements Invalidator. It reads all the entries.
+func (s *DiskStore) DeleteTags(ctx context.Context, tags ...string) error {
+	return s.deleteIf(ctx, func(e *Entry) bool { return e.hasTag(tags) })
+}
+
+func (s *DiskStore) deleteIf(ctx context.Context, match func(e *Entry) bool) error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if filepath.Ext(f.Name()) != ".entry" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := filepath.Join(s.dir, f.Name())
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		if e, err := DecodeEntry(b); err == nil && match(e) {
+			os.Remove(name)
+		}
+	}
+	return nil
+}
+
+// Purge implements Purger. It also removes the temporary files older than
+// an hour, using the time in their names, as their modification time may
+// already be set to the time the entry can be discarded.
+func (s *DiskStore) Purge(now time.Time) error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if filepath.Ext(f.Name()) == ".entry" && !now.Before(f.ModTime()) ||
+			strings.HasPrefix(f.Name(), tempPrefix) && now.Sub(tempCreated(f)) > tempMaxAge {
+			os.Remove(filepath.Join(s.dir, f.Name()))
+		}
+	}
+	return nil
+}
+
+// tempCreated returns the time a temporary file was created at, from its
+// name or, if it has no time, its modification time
+func tempCreated(f os.FileInfo) time.Time {
+	name := strings.TrimPrefix(f.Name(), tempPrefix)
+	if i := strings.IndexByte(name, '-'); i > 0 {
+		if sec, err := strconv.ParseInt(name[:i], 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+	}
+	return f.ModTime()
+}
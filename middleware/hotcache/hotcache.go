@@ -2,56 +2,504 @@
 // perform a single server request.
 // Is ideal for caching really hot pages, like front pages.
 // Is not ideal for caching responses that depends on the logged user.
+//
+// The cache follows the HTTP caching rules of shared caches: responses
+// marked with Cache-Control no-store, no-cache or private, setting cookies,
+// or with uncacheable status codes are not stored, and the lifetime of the
+// entries is taken from Cache-Control or Expires. The responses include
+// the Age and Cache-Status headers.
 package hotcache
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/estebarb/ion"
 	"github.com/estebarb/ion/futures"
+	"github.com/estebarb/ion/middleware"
 	"net/http"
-	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// cacheName identifies the cache in the Cache-Status header
+const cacheName = "hotcache"
+
+// etag returns a strong ETag computed from the body
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+}
+
+// Stats are the statistics of a cache
+type Stats struct {
+	// Hits is the number of requests served from the cache
+	Hits int64
+	// Misses is the number of requests that executed the handler, or
+	// waited for the execution of another request
+	Misses int64
+	// Evictions is the number of entries removed to respect the limits
+	Evictions int64
+	// Entries is the number of entries stored
+	Entries int
+	// Bytes is the approximate size of the entries stored
+	Bytes int64
+}
+
+// Default limits of the caches created with New
+const (
+	DefaultMaxBytes     = 64 << 20
+	DefaultMaxEntrySize = 1 << 20
+)
+
+// result is the outcome of the execution of the handler for a cache miss
+type result struct {
+	entry *Entry
+	// stored reports whether the entry was cached, so that it can be
+	// shared with the requests waiting for it
+	stored bool
+	// sent reports whether the response was written to the request that
+	// triggered the execution
+	sent bool
+	// recorded reports whether the whole response is in entry, as the
+	// streamed and large responses are not recorded
+	recorded bool
+	// failed reports whether the handler answered with a 5xx status or
+	// panicked, with the value in panicked
+	failed   bool
+	panicked interface{}
+	// shareable reports whether the response may be sent to other clients
+	// even if it is not stored, like the failures
+	shareable bool
+}
+
 // Config contains the configuration for hot caching of requests
 type Config struct {
 	l       sync.Mutex
 	timeout time.Duration
-	content map[string]*futures.Expirable
+	key     KeyFunc
+	store   Store
+	// staleWhileRevalidate and staleIfError are used for the responses
+	// that do not have the Cache-Control directives
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	// maxEntrySize is the size of the largest response stored
+	maxEntrySize int64
+	now          func() time.Time
+	lastPurge    time.Time
+	hits, misses int64
+	// tagHeader is the response header with the surrogate tags
+	tagHeader string
+	// generation is incremented by every invalidation, so that the
+	// executions started before it do not store their responses
+	generation int64
+	// flights are the executions of the handler in progress
+	flights map[string]*futures.Future[*result]
 }
 
-// New creates a new configurated Config for hot caching
+// New creates a new configurated Config for hot caching. The responses
+// are cached for the time given by their Cache-Control or Expires
+// headers, or for timeout if they have none.
+//
+// The entries are kept in a MemoryStore of up to DefaultMaxBytes, and the
+// responses larger than DefaultMaxEntrySize are not stored. The expired
+// entries are purged once per timeout.
 func New(timeout time.Duration) *Config {
 	return &Config{
-		timeout: timeout,
-		content: make(map[string]*futures.Expirable),
+		timeout:      timeout,
+		key:          DefaultKey,
+		store:        NewMemoryStore(0, DefaultMaxBytes),
+		maxEntrySize: DefaultMaxEntrySize,
+		tagHeader:    DefaultTagHeader,
+		now:          time.Now,
+		lastPurge:    time.Now(),
+		flights:      make(map[string]*futures.Future[*result]),
 	}
 }
 
-func execute(r *http.Request, next http.Handler) *httptest.ResponseRecorder {
-	wrec := httptest.NewRecorder()
-	next.ServeHTTP(wrec, r)
-	return wrec
+// WithKey sets the function that computes the cache keys of the requests
+func (c *Config) WithKey(key KeyFunc) *Config {
+	c.key = key
+	return c
+}
+
+// WithStore sets the Store that keeps the entries. The executions of the
+// handler are still coalesced in each process, so a shared store receives
+// at most one update per key from each of them.
+func (c *Config) WithStore(store Store) *Config {
+	c.store = store
+	return c
+}
+
+// WithLimits sets the maximum number of entries and total size of the
+// cache. A zero value means no limit. It only applies to a MemoryStore.
+func (c *Config) WithLimits(maxEntries int, maxBytes int64) *Config {
+	if m, ok := c.store.(*MemoryStore); ok {
+		m.SetLimits(maxEntries, maxBytes)
+	}
+	return c
+}
+
+// WithStale sets for how long the expired entries are served while they are
+// refreshed in the background (stale-while-revalidate), and for how long
+// they are served if the handler fails to refresh them with a 5xx status
+// or a panic (stale-if-error). The stale-while-revalidate and
+// stale-if-error Cache-Control directives of the responses take
+// precedence.
+func (c *Config) WithStale(whileRevalidate, ifError time.Duration) *Config {
+	c.staleWhileRevalidate = whileRevalidate
+	c.staleIfError = ifError
+	return c
+}
+
+// WithMaxEntrySize sets the size of the largest response stored, so that
+// large downloads are not cached. A zero value means no limit.
+func (c *Config) WithMaxEntrySize(size int64) *Config {
+	c.maxEntrySize = size
+	return c
+}
+
+// Stats returns the statistics of the cache. The number of entries, their
+// size and the evictions are only reported by stores with a
+// Stats() Stats method, like MemoryStore.
+func (c *Config) Stats() Stats {
+	var stats Stats
+	if s, ok := c.store.(interface{ Stats() Stats }); ok {
+		stats = s.Stats()
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+	stats.Hits = c.hits
+	stats.Misses = c.misses
+	return stats
+}
+
+// purge tells the store to remove the entries that are not retained
+// anymore, if a timeout passed since the last purge
+func (c *Config) purge(ctx context.Context, now time.Time) {
+	p, ok := c.store.(Purger)
+	if !ok {
+		return
+	}
+	c.l.Lock()
+	due := now.Sub(c.lastPurge) >= c.timeout
+	if due {
+		c.lastPurge = now
+	}
+	c.l.Unlock()
+	if !due {
+		return
+	}
+	if err := p.Purge(now); err != nil {
+		ion.LoggerFrom(ctx).Error("hotcache purge", "error", err.Error())
+	}
+}
+
+// varyKey returns the full key of a request, given the headers that the
+// responses for its base key vary on
+func varyKey(base string, r *http.Request, vary []string) string {
+	if len(vary) == 0 {
+		return base
+	}
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range vary {
+		b.WriteString("\x00vary:")
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// lookup returns the key of the entry of a request, and the entry if it
+// is stored. Store failures are logged and handled as misses.
+func (c *Config) lookup(r *http.Request, base string) (string, *Entry) {
+	ctx := r.Context()
+	key := base
+	e, err := c.store.Get(ctx, key)
+	if err == nil && e.isVary() {
+		key = varyKey(base, r, e.Vary)
+		e, err = c.store.Get(ctx, key)
+	}
+	if err != nil {
+		if err != ErrNotFound {
+			ion.LoggerFrom(ctx).Error("hotcache get", "key", key, "error", err.Error())
+		}
+		return key, nil
+	}
+	return key, e
+}
+
+// execute runs the handler for a request, writing the response to
+// capture. It runs with a clone of the request, detached from its
+// cancellation, as the response may be shared with the other requests
+// waiting for it even if the client that triggered it goes away.
+func (c *Config) execute(cp *capture, r *http.Request, next http.Handler) *Entry {
+	next.ServeHTTP(cp, r.Clone(context.WithoutCancel(r.Context())))
+	return cp.finish()
+}
+
+// fill executes the handler for a cache miss or a refresh, and stores the
+// response if it is cacheable. The response is written to w as it is
+// generated, unless w is nil or it is a 5xx and holdErrors is set. If the
+// handler fails the previous entry, if any, is kept so that it can be
+// served stale. Panics are recovered, as fill runs in its own goroutine,
+// and reported in the result.
+func (c *Config) fill(w http.ResponseWriter, r *http.Request, next http.Handler, base, key string, holdErrors bool) (res *result) {
+	cp := c.newCapture(w, r, base, holdErrors)
+	defer func() {
+		c.l.Lock()
+		delete(c.flights, key)
+		c.l.Unlock()
+		if p := recover(); p != nil {
+			res = &result{sent: cp.sent, failed: true, panicked: p}
+		}
+	}()
+
+	c.l.Lock()
+	generation := c.generation
+	c.l.Unlock()
+	e := c.execute(cp, r, next)
+	ttl, cacheable := freshness(r, e.Status, e.Header, e.Stored, c.timeout)
+	if cp.uncacheable || c.maxEntrySize > 0 && e.Size() > c.maxEntrySize {
+		cacheable = false
+	}
+	res = &result{
+		entry:     e,
+		sent:      cp.sent,
+		recorded:  !cp.uncacheable,
+		failed:    e.Status >= 500,
+		shareable: shareable(r, e.Header),
+	}
+	if res.failed {
+		return res
+	}
+	cc := parseCacheControl(e.Header)
+	e.StaleWhileRevalidate = c.staleWhileRevalidate
+	if d, ok := cc.seconds("stale-while-revalidate"); ok {
+		e.StaleWhileRevalidate = d
+	}
+	e.StaleIfError = c.staleIfError
+	if d, ok := cc.seconds("stale-if-error"); ok {
+		e.StaleIfError = d
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	logger := ion.LoggerFrom(ctx)
+	if !cacheable {
+		if err := c.store.Delete(ctx, key); err != nil {
+			logger.Error("hotcache delete", "key", key, "error", err.Error())
+		}
+		return res
+	}
+	c.l.Lock()
+	invalidated := c.generation != generation
+	c.l.Unlock()
+	if invalidated {
+		// The response may have been generated from the invalidated data
+		return res
+	}
+	if e.conditional() && e.Header.Get("ETag") == "" {
+		e.Header.Set("ETag", etag(e.Body))
+	}
+	e.Expires = e.Stored.Add(ttl)
+	if len(e.Vary) > 0 {
+		// Record the headers the responses vary on with the base key
+		err := c.store.Set(ctx, &Entry{
+			Key:                  base,
+			Vary:                 e.Vary,
+			Stored:               e.Stored,
+			Expires:              e.Expires,
+			StaleWhileRevalidate: e.StaleWhileRevalidate,
+			StaleIfError:         e.StaleIfError,
+		})
+		if err != nil {
+			logger.Error("hotcache set", "key", base, "error", err.Error())
+			return res
+		}
+	}
+	if err := c.store.Set(ctx, e); err != nil {
+		logger.Error("hotcache set", "key", e.Key, "error", err.Error())
+		return res
+	}
+	res.stored = true
+	c.purge(ctx, e.Stored)
+	return res
+}
+
+// ttlSeconds formats the remaining freshness of an entry for the
+// Cache-Status header, rounding down so that stale entries are negative
+func ttlSeconds(d time.Duration) string {
+	s := int64(d / time.Second)
+	if d < 0 && d%time.Second != 0 {
+		s--
+	}
+	return strconv.FormatInt(s, 10)
+}
+
+// serve writes a response, with the Age and Cache-Status headers. The
+// conditional (If-None-Match, If-Modified-Since...) and Range requests are
+// answered from the successful cached entries, which have an ETag and
+// a Last-Modified date, if they were generated for GET requests and have
+// no trailers. The HEAD entries keep the headers of the handler, as their
+// bodies are empty.
+func (c *Config) serve(w http.ResponseWriter, r *http.Request, e *Entry, status string) {
+	h := w.Header()
+	for k, v := range e.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	// The trailers are sent with http.TrailerPrefix instead
+	h.Del("Trailer")
+	age := c.now().Sub(e.Stored)
+	if age < 0 {
+		age = 0
+	}
+	h.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	h.Set("Cache-Status", cacheName+"; "+status)
+	cached := !e.Expires.IsZero()
+	if cached && e.conditional() {
+		modified, err := http.ParseTime(e.Header.Get("Last-Modified"))
+		if err != nil {
+			modified = e.Stored
+		}
+		http.ServeContent(w, r, "", modified, bytes.NewReader(e.Body))
+		return
+	}
+	w.WriteHeader(e.Status)
+	w.Write(e.Body)
+	for k, v := range e.Trailer {
+		h[http.TrailerPrefix+k] = append([]string(nil), v...)
+	}
 }
 
 // Middleware wraps a request and hot caches it
 func (c *Config) Middleware(next http.Handler) http.Handler {
+	return c.handler(next, c.key)
+}
+
+// Compressed returns a middleware that compresses the responses with the
+// given CompressConfig and hot caches them. Each response is compressed
+// once per negotiated encoding, instead of on every request as it would
+// happen if the compression middleware wrapped the cache.
+func (c *Config) Compressed(compress middleware.CompressConfig) ion.Middleware {
+	return func(next http.Handler) http.Handler {
+		return c.handler(compress.Middleware(next), func(r *http.Request) (string, bool) {
+			key, ok := c.key(r)
+			return key + "\x00" + compress.Negotiate(r), ok
+		})
+	}
+}
+
+// handler serves the requests from the cache. Concurrent requests for the
+// same missing entry wait for a single execution of the handler, and share
+// its response if it is cacheable. The responses that are not cacheable,
+// according to their status and Cache-Control, Expires, Vary and
+// Set-Cookie headers, are only sent to the request that generated them.
+// That request receives the response as it is generated, and the
+// responses that are flushed or hijacked are streamed to it without
+// being stored.
+//
+// The failures of the handler are shared with the requests waiting for
+// the execution too, so that a failing backend does not receive them all,
+// if they could be stored but for their status. Otherwise the requests
+// waiting receive a plain 500.
+//
+// Expired entries are served during their stale-while-revalidate period
+// while a single request refreshes them in the background, and during
+// their stale-if-error period when the refresh fails.
+func (c *Config) handler(next http.Handler, key KeyFunc) http.Handler {
 	fun := func(w http.ResponseWriter, r *http.Request) {
-		c.l.Lock()
-		expirable, ok := c.content[r.URL.Path]
+		base, ok := key(r)
 		if !ok {
-			expirable = futures.NewExpirable(c.timeout,
-				func() interface{} {
-					return execute(r, next)
-				})
-			c.content[r.URL.Path] = expirable
+			w.Header().Set("Cache-Status", cacheName+"; fwd=bypass")
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := c.now()
+		entryKey, e := c.lookup(r, base)
+		var stale *Entry
+		if e != nil {
+			ttl := "ttl=" + ttlSeconds(e.Expires.Sub(now))
+			switch {
+			case now.Before(e.Expires):
+				c.l.Lock()
+				c.hits++
+				c.l.Unlock()
+				c.serve(w, r, e, "hit; "+ttl)
+				return
+			case now.Before(e.Expires.Add(e.StaleWhileRevalidate)):
+				c.l.Lock()
+				c.hits++
+				if _, refreshing := c.flights[entryKey]; !refreshing {
+					// The refresh outlives this request, so it uses
+					// its own copy
+					refresh := r.Clone(context.WithoutCancel(r.Context()))
+					c.flights[entryKey] = futures.NewFutureFunc(func() *result {
+						return c.fill(nil, refresh, next, base, entryKey, false)
+					})
+				}
+				c.l.Unlock()
+				c.serve(w, r, e, "hit; "+ttl)
+				return
+			case now.Before(e.Expires.Add(e.StaleIfError)):
+				stale = e
+			}
+		}
+
+		c.l.Lock()
+		c.misses++
+		flight, collapsed := c.flights[entryKey]
+		if !collapsed {
+			// The response is written to this request as it is
+			// generated, unless it fails and a stale entry can be
+			// served instead
+			flight = futures.NewFutureFunc(func() *result {
+				return c.fill(w, r, next, base, entryKey, stale != nil)
+			})
+			c.flights[entryKey] = flight
 		}
 		c.l.Unlock()
-		recorded := expirable.Read().(*httptest.ResponseRecorder)
-		for k, v := range recorded.Header() {
-			w.Header()[k] = v
+
+		// The request that triggered the execution waits for it even if
+		// its client goes away, as the response is written to it
+		ctx := context.Background()
+		if collapsed {
+			ctx = r.Context()
+		}
+		res, err := flight.Get(ctx)
+		if err != nil {
+			return
+		}
+		if res.failed && stale != nil && (collapsed || !res.sent) {
+			c.serve(w, r, stale, "fwd=stale; ttl="+ttlSeconds(stale.Expires.Sub(c.now()))+
+				"; detail=stale-if-error")
+			return
+		}
+		switch {
+		case res.panicked != nil && !collapsed:
+			panic(res.panicked)
+		case !collapsed:
+			// The response was written by the execution
+		case res.failed && res.recorded && res.shareable && res.entry.Key == varyKey(base, r, res.entry.Vary):
+			// Share the error instead of executing the handler again,
+			// which would overload a failing backend
+			c.serve(w, r, res.entry.shared(), "fwd=miss; collapsed")
+		case res.failed:
+			// Only the request that triggered the execution reports
+			// the panic, and the failures that can not be shared are
+			// not detailed
+			ion.WriteError(w, r, http.StatusInternalServerError, "", "")
+		case res.stored && res.entry.Key == varyKey(base, r, res.entry.Vary):
+			c.serve(w, r, res.entry, "fwd=miss; collapsed")
+		default:
+			// The response can not be shared, so handle the request
+			w.Header().Set("Cache-Status", cacheName+"; fwd=miss")
+			next.ServeHTTP(w, r)
 		}
-		w.WriteHeader(recorded.Code)
-		w.Write(recorded.Body.Bytes())
 	}
 	return http.HandlerFunc(fun)
 }
@@ -1,9 +1,13 @@
 package hotcache
 
 import (
+	"compress/gzip"
+	"context"
+	"github.com/estebarb/ion/middleware"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -57,3 +61,188 @@ func TestConfig_Middleware(t *testing.T) {
 		t.Errorf("Expected duration was more or less %v, takes %v", ExpectedDuration, duration)
 	}
 }
+
+func TestConfig_Compressed(t *testing.T) {
+	calls := 0
+	body := strings.Repeat("hello world ", 200)
+	hc := New(time.Second * 3)
+	h := hc.Compressed(middleware.CompressConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	}))
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("Expecting a gzip response, got %v", w.Header())
+		}
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := ioutil.ReadAll(zr)
+		if string(buf) != body {
+			t.Errorf("Unexpected body %q", buf)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Errorf("Expecting an uncompressed response, got %v", w.Header())
+	}
+
+	if calls != 2 {
+		t.Errorf("Expecting the handler to run once per encoding, ran %d times", calls)
+	}
+}
+
+func TestConfig_Key(t *testing.T) {
+	calls := 0
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(r.Method + " " + r.URL.RawQuery + " " + r.Header.Get("Accept-Language")))
+	})
+	hc := New(time.Second * 3).WithKey(KeyConfig{
+		QueryParams: []string{"q", "page"},
+		Headers:     []string{"Accept-Language"},
+	}.Key)
+	h := hc.Middleware(echo)
+
+	get := func(method, target, lang string) string {
+		req := httptest.NewRequest(method, target, nil)
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	cases := []struct {
+		method, target, lang, body string
+		calls                      int
+	}{
+		{http.MethodGet, "/search?q=a&page=1", "", "GET q=a&page=1 ", 1},
+		{http.MethodGet, "/search?page=1&q=a&utm=x", "", "GET q=a&page=1 ", 1},
+		{http.MethodGet, "/search?q=b", "", "GET q=b ", 2},
+		{http.MethodGet, "/search?q=b", "es", "GET q=b es", 3},
+		{http.MethodPost, "/search?q=b", "", "POST q=b ", 4},
+		{http.MethodPost, "/search?q=b", "", "POST q=b ", 5},
+		{http.MethodGet, "/search?q=b", "", "GET q=b ", 5},
+	}
+	for i, c := range cases {
+		if body := get(c.method, c.target, c.lang); body != c.body || calls != c.calls {
+			t.Errorf("Case %d: expected %q after %d calls, got %q after %d", i, c.body, c.calls, body, calls)
+		}
+	}
+}
+
+func TestDefaultKey(t *testing.T) {
+	cases := []struct {
+		method, target string
+		key            string
+		ok             bool
+	}{
+		{http.MethodGet, "/search?b=2&a=1", "GET /search?a=1&b=2", true},
+		{http.MethodHead, "/", "HEAD /", true},
+		{http.MethodPost, "/", "", false},
+	}
+	for _, c := range cases {
+		key, ok := DefaultKey(httptest.NewRequest(c.method, c.target, nil))
+		if key != c.key || ok != c.ok {
+			t.Errorf("%s %s: expected %q %v, got %q %v", c.method, c.target, c.key, c.ok, key, ok)
+		}
+	}
+}
+
+type userKey struct{}
+
+func TestConfig_RegeneratesWithCurrentRequest(t *testing.T) {
+	hc := New(time.Millisecond * 20)
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.Context().Err(); err != nil {
+			t.Errorf("Regenerating with a cancelled context: %v", err)
+		}
+		w.Write([]byte(r.Header.Get("X-Version") + " " + r.Context().Value(userKey{}).(string)))
+	}))
+
+	serve := func(version, user string, cancel bool) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Version", version)
+		ctx, cancelFunc := context.WithCancel(context.WithValue(req.Context(), userKey{}, user))
+		if cancel {
+			cancelFunc()
+		} else {
+			defer cancelFunc()
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req.WithContext(ctx))
+		return w.Body.String()
+	}
+
+	if body := serve("1", "frank", true); body != "1 frank" {
+		t.Errorf("Expected '1 frank', got %q", body)
+	}
+	if body := serve("2", "alice", false); body != "1 frank" {
+		t.Errorf("Expected the cached '1 frank', got %q", body)
+	}
+	time.Sleep(time.Millisecond * 30)
+	if body := serve("3", "bob", false); body != "3 bob" {
+		t.Errorf("Expected the entry to be regenerated with the current request, got %q", body)
+	}
+}
+
+func TestConfig_Eviction(t *testing.T) {
+	now := time.Now()
+	hc := New(time.Minute).WithLimits(2, 0).WithMaxEntrySize(100)
+	hc.now = func() time.Time { return now }
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			w.Write([]byte(strings.Repeat("x", 200)))
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Header().Get("Cache-Status")
+	}
+
+	get("/a")
+	get("/b")
+	get("/a")
+	get("/c") // evicts /b, the least recently used
+	if status := get("/a"); !strings.Contains(status, "hit") {
+		t.Errorf("Expected /a to be kept, got %q", status)
+	}
+	if status := get("/b"); strings.Contains(status, "hit") {
+		t.Errorf("Expected /b to be evicted, got %q", status)
+	}
+	get("/large")
+	if status := get("/large"); strings.Contains(status, "hit") {
+		t.Errorf("Expected large responses not to be cached, got %q", status)
+	}
+
+	stats := hc.Stats()
+	expected := Stats{Hits: 2, Misses: 6, Evictions: 2, Entries: 2, Bytes: stats.Bytes}
+	if stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+
+	now = now.Add(2 * time.Minute)
+	get("/d")
+	if stats := hc.Stats(); stats.Entries != 1 {
+		t.Errorf("Expected the expired entries to be purged, got %+v", stats)
+	}
+	hc.WithLimits(0, 10)
+	get("/e")
+	if status := get("/e"); strings.Contains(status, "hit") {
+		t.Errorf("Expected the entries larger than the cache not to be stored, got %q", status)
+	}
+}
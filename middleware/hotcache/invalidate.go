@@ -0,0 +1,138 @@
+package hotcache
+
+import (
+	"context"
+	"errors"
+	"github.com/estebarb/ion"
+	"net/http"
+	"strings"
+)
+
+// DefaultTagHeader is the response header used by the handlers to tag
+// the responses, with space separated surrogate tags:
+//
+//	w.Header().Set("Surrogate-Key", "articles article-42")
+const DefaultTagHeader = "Surrogate-Key"
+
+// ErrInvalidationUnsupported is returned when invalidating by prefix or
+// tags a store that does not implement Invalidator
+var ErrInvalidationUnsupported = errors.New("hotcache: store does not support invalidation by prefix or tags")
+
+// WithTagHeader sets the response header with the surrogate tags. The
+// header is removed from the responses sent to the clients.
+func (c *Config) WithTagHeader(name string) *Config {
+	c.tagHeader = name
+	return c
+}
+
+// tags returns the surrogate tags of a response, removing their header
+func (c *Config) tags(h http.Header) []string {
+	if c.tagHeader == "" {
+		return nil
+	}
+	var tags []string
+	for _, value := range h.Values(c.tagHeader) {
+		tags = append(tags, strings.Fields(value)...)
+	}
+	h.Del(c.tagHeader)
+	return tags
+}
+
+// invalidated discards the responses being generated, as they may come
+// from the invalidated data
+func (c *Config) invalidated() {
+	c.l.Lock()
+	c.generation++
+	c.l.Unlock()
+}
+
+// Invalidate removes the entry with the given key, like "GET /news" for
+// DefaultKey, and its variants by Vary headers, configured headers and
+// cookies, or encoding. Only the entry with the exact key is removed if
+// the store does not implement Invalidator.
+func (c *Config) Invalidate(ctx context.Context, key string) error {
+	c.invalidated()
+	if err := c.store.Delete(ctx, key); err != nil {
+		return err
+	}
+	if inv, ok := c.store.(Invalidator); ok {
+		// The variants are stored with the key followed by "\x00"
+		return inv.DeletePrefix(ctx, key+"\x00")
+	}
+	return nil
+}
+
+// InvalidatePrefix removes the entries whose key starts with prefix, like
+// "GET /news/" for DefaultKey
+func (c *Config) InvalidatePrefix(ctx context.Context, prefix string) error {
+	inv, ok := c.store.(Invalidator)
+	if !ok {
+		return ErrInvalidationUnsupported
+	}
+	c.invalidated()
+	return inv.DeletePrefix(ctx, prefix)
+}
+
+// InvalidateTags removes the entries with any of the surrogate tags
+func (c *Config) InvalidateTags(ctx context.Context, tags ...string) error {
+	inv, ok := c.store.(Invalidator)
+	if !ok {
+		return ErrInvalidationUnsupported
+	}
+	c.invalidated()
+	return inv.DeleteTags(ctx, tags...)
+}
+
+// Admin returns a handler that invalidates entries with POST or DELETE
+// requests, given the key, prefix or tag parameters in the query string
+// or form. It answers 204 when done:
+//
+//	DELETE /cache?key=GET+/news
+//	DELETE /cache?prefix=GET+/news/
+//	DELETE /cache?tag=articles&tag=article-42
+//
+// It does not authenticate the requests, so it must be protected, for
+// example with ion.Authorize.
+func (c *Config) Admin() http.Handler {
+	fun := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		keys, prefixes, tags := r.Form["key"], r.Form["prefix"], r.Form["tag"]
+		if len(keys) == 0 && len(prefixes) == 0 && len(tags) == 0 {
+			http.Error(w, "key, prefix or tag required", http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		var err error
+		for _, key := range keys {
+			if err == nil {
+				err = c.Invalidate(ctx, key)
+			}
+		}
+		for _, prefix := range prefixes {
+			if err == nil {
+				err = c.InvalidatePrefix(ctx, prefix)
+			}
+		}
+		if err == nil && len(tags) > 0 {
+			err = c.InvalidateTags(ctx, tags...)
+		}
+		switch {
+		case err == ErrInvalidationUnsupported:
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+		case err != nil:
+			ion.LoggerFrom(ctx).Error("hotcache invalidate", "error", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+	return http.HandlerFunc(fun)
+}
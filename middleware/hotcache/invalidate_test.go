@@ -0,0 +1,132 @@
+package hotcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_Invalidate(t *testing.T) {
+	hc := New(time.Minute)
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/articles/") {
+			w.Header().Set("Surrogate-Key", "articles "+strings.TrimPrefix(r.URL.Path, "/articles/"))
+		}
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.URL.Path))
+	}))
+	get := func(path, lang string) (string, http.Header) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Header().Get("Cache-Status"), w.Header()
+	}
+	hit := func(path, lang string) bool {
+		status, _ := get(path, lang)
+		return strings.Contains(status, "hit")
+	}
+	fill := func() {
+		for _, path := range []string{"/", "/news", "/articles/1", "/articles/2"} {
+			get(path, "en")
+			get(path, "es")
+		}
+	}
+	ctx := context.Background()
+
+	fill()
+	if _, header := get("/articles/1", "en"); header.Get("Surrogate-Key") != "" {
+		t.Errorf("Expected the tag header to be removed, got %v", header)
+	}
+	if err := hc.Invalidate(ctx, "GET /news"); err != nil {
+		t.Fatal(err)
+	}
+	if hit("/news", "en") || hit("/news", "es") {
+		t.Error("Expected /news to be invalidated in all the languages")
+	}
+	if !hit("/", "en") || !hit("/articles/1", "es") {
+		t.Error("Expected the other entries to be kept")
+	}
+
+	fill()
+	if err := hc.InvalidateTags(ctx, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if hit("/articles/2", "en") || !hit("/articles/1", "en") {
+		t.Error("Expected only the entries tagged with 2 to be invalidated")
+	}
+
+	fill()
+	if err := hc.InvalidatePrefix(ctx, "GET /articles/"); err != nil {
+		t.Fatal(err)
+	}
+	if hit("/articles/1", "es") || hit("/articles/2", "en") || !hit("/news", "en") {
+		t.Error("Expected only the entries under /articles/ to be invalidated")
+	}
+}
+
+func TestConfig_InvalidateDuringExecution(t *testing.T) {
+	hc := New(time.Minute)
+	started, invalidated := make(chan struct{}), make(chan struct{})
+	version := "1"
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := version
+		if body == "1" {
+			close(started)
+			<-invalidated
+		}
+		w.Write([]byte(body))
+	}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	<-started
+	version = "2"
+	hc.Invalidate(context.Background(), "GET /")
+	close(invalidated)
+	<-done
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Body.String() != "2" {
+		t.Errorf("Expected the response generated before the invalidation not to be stored, got %q", w.Body.String())
+	}
+}
+
+func TestConfig_Admin(t *testing.T) {
+	hc := New(time.Minute)
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Surrogate-Key", "all")
+		w.Write([]byte("hello"))
+	}))
+	admin := hc.Admin()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	cases := []struct {
+		method, target string
+		status         int
+	}{
+		{http.MethodGet, "/cache?tag=all", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/cache", http.StatusBadRequest},
+		{http.MethodDelete, "/cache?tag=all", http.StatusNoContent},
+		{http.MethodPost, "/cache?key=GET+/&prefix=GET+/news", http.StatusNoContent},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(c.method, c.target, nil))
+		if w.Code != c.status {
+			t.Errorf("%s %s: expected %d, got %d", c.method, c.target, c.status, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if status := w.Header().Get("Cache-Status"); strings.Contains(status, "hit") {
+		t.Errorf("Expected the entry to be invalidated, got %q", status)
+	}
+}
@@ -0,0 +1,89 @@
+package hotcache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KeyFunc returns the key of the cache entry of a request. Requests with
+// equal keys share the same response. It returns false if the request must
+// not be cached, in which case it is handled directly.
+type KeyFunc func(r *http.Request) (key string, ok bool)
+
+// KeyConfig configures the cache keys of the requests
+type KeyConfig struct {
+	// Methods lists the cacheable methods. GET and HEAD are used if empty.
+	// The method is always part of the key.
+	Methods []string
+	// IgnoreQuery excludes the query string from the key
+	IgnoreQuery bool
+	// QueryParams, if not empty, lists the only query parameters included
+	// in the key
+	QueryParams []string
+	// Headers lists the request headers included in the key, like
+	// Accept-Language
+	Headers []string
+	// Cookies lists the cookies included in the key
+	Cookies []string
+}
+
+// Key is a KeyFunc that builds the key from the method, the path, the
+// query string with its parameters sorted, and the configured headers
+// and cookies
+func (k KeyConfig) Key(r *http.Request) (string, bool) {
+	methods := k.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+	cacheable := false
+	for _, m := range methods {
+		if r.Method == m {
+			cacheable = true
+			break
+		}
+	}
+	if !cacheable {
+		return "", false
+	}
+
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteString(" ")
+	b.WriteString(r.URL.Path)
+	if !k.IgnoreQuery {
+		query := r.URL.Query()
+		if len(k.QueryParams) > 0 {
+			selected := make(url.Values)
+			for _, name := range k.QueryParams {
+				if values, ok := query[name]; ok {
+					selected[name] = values
+				}
+			}
+			query = selected
+		}
+		if len(query) > 0 {
+			b.WriteString("?")
+			b.WriteString(query.Encode())
+		}
+	}
+	for _, name := range k.Headers {
+		b.WriteString("\x00")
+		b.WriteString(http.CanonicalHeaderKey(name))
+		b.WriteString(":")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	for _, name := range k.Cookies {
+		b.WriteString("\x00cookie:")
+		b.WriteString(name)
+		if c, err := r.Cookie(name); err == nil {
+			b.WriteString("=")
+			b.WriteString(c.Value)
+		}
+	}
+	return b.String(), true
+}
+
+// DefaultKey is the KeyFunc used by default. It only caches GET and HEAD
+// requests, keyed by method, path and query string.
+var DefaultKey KeyFunc = KeyConfig{}.Key
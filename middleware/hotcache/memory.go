@@ -0,0 +1,155 @@
+package hotcache
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps the entries in memory, evicting the
+// least recently used when the limits are exceeded
+type MemoryStore struct {
+	l          sync.Mutex
+	maxEntries int
+	maxBytes   int64
+
+	ll        *list.List
+	items     map[string]*list.Element
+	bytes     int64
+	evictions int64
+}
+
+type memoryItem struct {
+	entry *Entry
+	size  int64
+}
+
+// NewMemoryStore creates a MemoryStore that keeps up to maxEntries entries
+// and maxBytes bytes. A zero value means no limit. The entries larger than
+// maxBytes are not stored.
+func NewMemoryStore(maxEntries int, maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// SetLimits changes the limits of the store, evicting entries if needed
+func (s *MemoryStore) SetLimits(maxEntries int, maxBytes int64) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.maxEntries = maxEntries
+	s.maxBytes = maxBytes
+	s.evict()
+}
+
+// Get implements Store. It marks the entry as recently used.
+func (s *MemoryStore) Get(ctx context.Context, key string) (*Entry, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	s.ll.MoveToFront(el)
+	return el.Value.(*memoryItem).entry, nil
+}
+
+// Set implements Store
+func (s *MemoryStore) Set(ctx context.Context, e *Entry) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.remove(e.Key)
+	item := &memoryItem{entry: e, size: e.Size()}
+	if s.maxBytes > 0 && item.size > s.maxBytes {
+		return nil
+	}
+	s.items[e.Key] = s.ll.PushFront(item)
+	s.bytes += item.size
+	s.evict()
+	return nil
+}
+
+// evict removes the least recently used entries until the limits
+// are respected
+func (s *MemoryStore) evict() {
+	for s.ll.Len() > 0 && (s.maxEntries > 0 && s.ll.Len() > s.maxEntries ||
+		s.maxBytes > 0 && s.bytes > s.maxBytes) {
+		s.removeElement(s.ll.Back())
+		s.evictions++
+	}
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.remove(key)
+	return nil
+}
+
+func (s *MemoryStore) remove(key string) {
+	if el, ok := s.items[key]; ok {
+		s.removeElement(el)
+	}
+}
+
+func (s *MemoryStore) removeElement(el *list.Element) {
+	item := s.ll.Remove(el).(*memoryItem)
+	delete(s.items, item.entry.Key)
+	s.bytes -= item.size
+}
+
+// DeletePrefix implements Invalidator
+func (s *MemoryStore) DeletePrefix(ctx context.Context, prefix string) error {
+	s.deleteIf(func(e *Entry) bool { return strings.HasPrefix(e.Key, prefix) })
+	return nil
+}
+
+// DeleteTags implements Invalidator
+func (s *MemoryStore) DeleteTags(ctx context.Context, tags ...string) error {
+	s.deleteIf(func(e *Entry) bool { return e.hasTag(tags) })
+	return nil
+}
+
+func (s *MemoryStore) deleteIf(match func(e *Entry) bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	for el := s.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if match(el.Value.(*memoryItem).entry) {
+			s.removeElement(el)
+		}
+		el = prev
+	}
+}
+
+// Purge implements Purger
+func (s *MemoryStore) Purge(now time.Time) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	for el := s.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if !now.Before(el.Value.(*memoryItem).entry.Retained()) {
+			s.removeElement(el)
+		}
+		el = prev
+	}
+	return nil
+}
+
+// Stats returns the number of entries, their size and the number of
+// evictions
+func (s *MemoryStore) Stats() Stats {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return Stats{
+		Evictions: s.evictions,
+		Entries:   s.ll.Len(),
+		Bytes:     s.bytes,
+	}
+}
@@ -0,0 +1,134 @@
+package hotcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl is a parsed Cache-Control header
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, arg = directive[:i], strings.Trim(directive[i+1:], `"`)
+			}
+			cc[strings.ToLower(name)] = arg
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the value of a directive with a duration in seconds
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	arg, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, true
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// cacheableStatus lists the status codes that are cacheable by default
+// (RFC 9110, section 15.1)
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// varyHeaders returns the request headers listed in the Vary header,
+// and false if the response varies on "*"
+func varyHeaders(h http.Header) ([]string, bool) {
+	var headers []string
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				headers = append(headers, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return headers, true
+}
+
+// shareable reports whether the response to r may be sent to other
+// clients, ignoring its cookies. The responses marked no-store or private,
+// or varying on "*", are not. The responses to requests with an
+// Authorization header are only shareable if they are marked public,
+// s-maxage or must-revalidate, as they may be specific to the client.
+func shareable(r *http.Request, h http.Header) bool {
+	if _, ok := varyHeaders(h); !ok {
+		return false
+	}
+	cc := parseCacheControl(h)
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	if _, ok := r.Header["Authorization"]; ok && !cc.has("public") && !cc.has("must-revalidate") {
+		if _, ok := cc.seconds("s-maxage"); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// freshness returns how long the response to r may be cached by a shared
+// cache, or false if it must not be cached. The lifetime is taken from the
+// s-maxage or max-age directives, or the Expires header, and defaults to
+// the given duration.
+func freshness(r *http.Request, status int, h http.Header, now time.Time, fallback time.Duration) (time.Duration, bool) {
+	if !cacheableStatus[status] || len(h.Values("Set-Cookie")) > 0 || !shareable(r, h) {
+		return 0, false
+	}
+	cc := parseCacheControl(h)
+	if cc.has("no-cache") {
+		return 0, false
+	}
+	ttl := fallback
+	if d, ok := cc.seconds("s-maxage"); ok {
+		ttl = d
+	} else if d, ok := cc.seconds("max-age"); ok {
+		ttl = d
+	} else if expires := h.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// Invalid dates, like "0", mean already expired
+			return 0, false
+		}
+		date := now
+		if d, err := http.ParseTime(h.Get("Date")); err == nil {
+			date = d
+		}
+		ttl = t.Sub(date)
+	}
+	return ttl, ttl > 0
+}
@@ -0,0 +1,114 @@
+package hotcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		status int
+		header http.Header
+		ttl    time.Duration
+		ok     bool
+	}{
+		{200, http.Header{}, time.Minute, true},
+		{500, http.Header{}, 0, false},
+		{404, http.Header{}, time.Minute, true},
+		{200, http.Header{"Cache-Control": {"no-store"}}, 0, false},
+		{200, http.Header{"Cache-Control": {"public, no-cache"}}, 0, false},
+		{200, http.Header{"Cache-Control": {"private, max-age=60"}}, 0, false},
+		{200, http.Header{"Set-Cookie": {"a=b"}}, 0, false},
+		{200, http.Header{"Vary": {"*"}}, 0, false},
+		{200, http.Header{"Cache-Control": {"max-age=10"}}, 10 * time.Second, true},
+		{200, http.Header{"Cache-Control": {"max-age=10, s-maxage=\"20\""}}, 20 * time.Second, true},
+		{200, http.Header{"Cache-Control": {"max-age=0"}}, 0, false},
+		{200, http.Header{
+			"Date":    {now.Format(http.TimeFormat)},
+			"Expires": {now.Add(time.Hour).Format(http.TimeFormat)},
+		}, time.Hour, true},
+		{200, http.Header{"Expires": {"0"}}, 0, false},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for i, c := range cases {
+		ttl, ok := freshness(req, c.status, c.header, now, time.Minute)
+		if ttl != c.ttl && c.ok || ok != c.ok {
+			t.Errorf("Case %d: expected %v %v, got %v %v", i, c.ttl, c.ok, ttl, ok)
+		}
+	}
+}
+
+func TestConfig_CachingSemantics(t *testing.T) {
+	now := time.Now()
+	calls := 0
+	hc := New(time.Minute)
+	hc.now = func() time.Time { return now }
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/short":
+			w.Header().Set("Cache-Control", "max-age=10")
+		case "/private":
+			w.Header().Set("Cache-Control", "private")
+		case "/public":
+			w.Header().Set("Cache-Control", "public")
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/lang":
+			w.Header().Set("Vary", "Accept-Language")
+		}
+		fmt.Fprint(w, calls, r.Header.Get("Accept-Language"))
+	}))
+
+	get := func(path, lang string) *httptest.ResponseRecorder {
+		// The paths under /auth are requested with credentials
+		req := httptest.NewRequest(http.MethodGet, strings.TrimPrefix(path, "/auth"), nil)
+		if strings.HasPrefix(path, "/auth/") {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/short", "")
+	if w.Header().Get("Cache-Status") != "hotcache; fwd=miss; stored" || w.Header().Get("Age") != "0" {
+		t.Errorf("Unexpected headers for a miss %v", w.Header())
+	}
+	now = now.Add(4 * time.Second)
+	w = get("/short", "")
+	if w.Body.String() != "1" || w.Header().Get("Cache-Status") != "hotcache; hit; ttl=6" || w.Header().Get("Age") != "4" {
+		t.Errorf("Unexpected hit %q %v", w.Body.String(), w.Header())
+	}
+	now = now.Add(10 * time.Second)
+	if w = get("/short", ""); w.Body.String() != "2" {
+		t.Errorf("Expected the entry to expire after max-age, got %q", w.Body.String())
+	}
+
+	for _, path := range []string{"/private", "/error", "/auth/"} {
+		first := get(path, "").Body.String()
+		w = get(path, "")
+		if w.Body.String() == first || w.Header().Get("Cache-Status") != "hotcache; fwd=miss" {
+			t.Errorf("%s: expected the response not to be cached, got %q %v", path, w.Body.String(), w.Header())
+		}
+	}
+
+	get("/auth/public", "")
+	if w = get("/auth/public", ""); !strings.Contains(w.Header().Get("Cache-Status"), "hit") {
+		t.Errorf("Expected the public response to an authorized request to be cached, got %v", w.Header())
+	}
+
+	en := get("/lang", "en").Body.String()
+	es := get("/lang", "es").Body.String()
+	if en == es || get("/lang", "en").Body.String() != en || get("/lang", "es").Body.String() != es {
+		t.Errorf("Expected an entry for each language, got %q and %q", en, es)
+	}
+}
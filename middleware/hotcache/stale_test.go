@@ -0,0 +1,185 @@
+package hotcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConfig_StaleWhileRevalidate(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	hc := New(50*time.Millisecond).WithStale(time.Minute, 0)
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if n > 1 {
+			<-release
+		}
+		fmt.Fprint(w, n)
+	}))
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+
+	get()
+	time.Sleep(60 * time.Millisecond)
+
+	// The expired entry is served without waiting for the slow refresh
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := get(); w.Body.String() != "1" || !strings.Contains(w.Header().Get("Cache-Status"), "hit; ttl=-") {
+				t.Errorf("Expected the stale entry, got %q %v", w.Body.String(), w.Header())
+			}
+		}()
+	}
+	wg.Wait()
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for get().Body.String() != "2" {
+		if time.Now().After(deadline) {
+			t.Fatal("The entry was not refreshed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Expected a single refresh, the handler ran %d times", n)
+	}
+}
+
+func TestConfig_StaleIfError(t *testing.T) {
+	now := time.Now()
+	failure := ""
+	hc := New(time.Minute).WithStale(0, time.Hour)
+	hc.now = func() time.Time { return now }
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch failure {
+		case "panic":
+			panic("boom")
+		case "error":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "unavailable")
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	get := func() (w *httptest.ResponseRecorder, panicked interface{}) {
+		defer func() { panicked = recover() }()
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w, nil
+	}
+
+	get()
+	now = now.Add(2 * time.Minute)
+	for _, failure = range []string{"error", "panic"} {
+		w, p := get()
+		if p != nil || w.Code != http.StatusOK || w.Body.String() != "ok" ||
+			!strings.Contains(w.Header().Get("Cache-Status"), "stale-if-error") {
+			t.Errorf("%s: expected the stale entry, got %v %d %q", failure, p, w.Code, w.Body.String())
+		}
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, p := get(); p != "boom" {
+		t.Errorf("Expected the panic after the grace period, got %v", p)
+	}
+	failure = "error"
+	if w, _ := get(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the error after the grace period, got %d", w.Code)
+	}
+}
+
+func TestConfig_SharedFailures(t *testing.T) {
+	var calls int32
+	hc := New(time.Minute)
+	h := hc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		switch r.URL.Path {
+		case "/panic":
+			panic("boom")
+		case "/private":
+			w.Header().Set("Cache-Control", "private")
+		}
+		w.Header().Set("Set-Cookie", "id=1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("down"))
+	}))
+	// serve runs concurrent requests, and returns the responses of the
+	// ones that did not panic
+	serve := func(path string) ([]*httptest.ResponseRecorder, int32) {
+		var panics int32
+		var l sync.Mutex
+		var responses []*httptest.ResponseRecorder
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					if recover() != nil {
+						atomic.AddInt32(&panics, 1)
+					}
+				}()
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				l.Lock()
+				responses = append(responses, w)
+				l.Unlock()
+			}()
+			time.Sleep(time.Millisecond)
+		}
+		wg.Wait()
+		return responses, panics
+	}
+
+	responses, _ := serve("/error")
+	for _, w := range responses {
+		collapsed := strings.Contains(w.Header().Get("Cache-Status"), "collapsed")
+		if w.Code != http.StatusServiceUnavailable || w.Body.String() != "down" ||
+			collapsed && w.Header().Get("Set-Cookie") != "" {
+			t.Errorf("Unexpected response %d %q %v", w.Code, w.Body.String(), w.Header())
+		}
+	}
+	if n := atomic.SwapInt32(&calls, 0); n != 1 {
+		t.Errorf("Expected the error to be shared, the handler ran %d times", n)
+	}
+
+	responses, _ = serve("/private")
+	for _, w := range responses {
+		collapsed := strings.Contains(w.Header().Get("Cache-Status"), "collapsed")
+		if collapsed || w.Code == http.StatusServiceUnavailable && w.Header().Get("Set-Cookie") == "" {
+			t.Errorf("Expected the private error not to be shared, got %d %q %v", w.Code, w.Body.String(), w.Header())
+		}
+		if w.Code != http.StatusServiceUnavailable && (w.Code != http.StatusInternalServerError || w.Body.String() == "down") {
+			t.Errorf("Expected a plain error for the waiting requests, got %d %q", w.Code, w.Body.String())
+		}
+	}
+	if n := atomic.SwapInt32(&calls, 0); n != 1 {
+		t.Errorf("Expected the waiting requests not to execute the handler, it ran %d times", n)
+	}
+
+	responses, panics := serve("/panic")
+	if panics != 1 || len(responses) != 4 {
+		t.Errorf("Expected the panic to be raised once, got %d", panics)
+	}
+	for _, w := range responses {
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected 500 for the waiting requests, got %d", w.Code)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected the panic to be shared, the handler ran %d times", n)
+	}
+}
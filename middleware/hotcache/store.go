@@ -0,0 +1,151 @@
+package hotcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrNotFound is returned by the stores when there is no entry
+var ErrNotFound = errors.New("hotcache: entry not found")
+
+// Entry is a cached response. A response that varies on request headers
+// is stored with a key that includes their values, and the key of the
+// request without them maps to an Entry with a zero Status that only
+// lists the headers in Vary.
+type Entry struct {
+	Key string
+	// Method is the method of the request the response was generated for
+	Method string
+	Status int
+	Header http.Header
+	Body   []byte
+	// Trailer contains the trailers sent after the body
+	Trailer http.Header
+	// Vary lists the request headers the response depends on
+	Vary []string
+	// Tags are the surrogate tags of the response, used to invalidate it
+	Tags []string
+	// Stored is the time the response was generated, and Expires the
+	// time until it is fresh
+	Stored  time.Time
+	Expires time.Time
+	// StaleWhileRevalidate and StaleIfError are the times after Expires
+	// that the entry may be served while it is refreshed, or if the
+	// refresh fails
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+}
+
+// Encode serializes the entry with encoding/gob
+func (e *Entry) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeEntry deserializes an entry serialized with Entry.Encode
+func DecodeEntry(b []byte) (*Entry, error) {
+	e := &Entry{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Size returns the approximate memory used by the entry
+func (e *Entry) Size() int64 {
+	n := int64(len(e.Key) + len(e.Body))
+	for _, h := range []http.Header{e.Header, e.Trailer} {
+		for k, v := range h {
+			n += int64(len(k))
+			for _, s := range v {
+				n += int64(len(s))
+			}
+		}
+	}
+	for _, v := range e.Vary {
+		n += int64(len(v))
+	}
+	for _, v := range e.Tags {
+		n += int64(len(v))
+	}
+	return n
+}
+
+// Retained returns the time until which the entry must be kept, including
+// the time it may be served stale
+func (e *Entry) Retained() time.Time {
+	if e.StaleWhileRevalidate > e.StaleIfError {
+		return e.Expires.Add(e.StaleWhileRevalidate)
+	}
+	return e.Expires.Add(e.StaleIfError)
+}
+
+// conditional reports whether the conditional and Range requests can be
+// answered from the entry
+func (e *Entry) conditional() bool {
+	return e.Status == http.StatusOK && e.Method == http.MethodGet && len(e.Trailer) == 0
+}
+
+// shared returns a copy of an uncached entry that can be sent to other
+// clients, without the cookies
+func (e *Entry) shared() *Entry {
+	c := *e
+	c.Header = e.Header.Clone()
+	c.Header.Del("Set-Cookie")
+	return &c
+}
+
+// isVary reports whether the entry only lists the headers the responses
+// for a key vary on
+func (e *Entry) isVary() bool {
+	return e.Status == 0
+}
+
+// Store keeps the cached entries, for example in memory, on disk, or in
+// a database shared between replicas. The entries are returned even if
+// they expired, as they may still be served stale, but they can be
+// discarded after Entry.Retained. The entries returned must not be
+// modified.
+type Store interface {
+	// Get returns the entry with the given key, or ErrNotFound
+	Get(ctx context.Context, key string) (*Entry, error)
+	// Set stores the entry with its Key
+	Set(ctx context.Context, e *Entry) error
+	// Delete removes the entry with the given key
+	Delete(ctx context.Context, key string) error
+}
+
+// Purger is implemented by the stores that must be told to remove the
+// entries that are not retained anymore. It is called periodically.
+type Purger interface {
+	Purge(now time.Time) error
+}
+
+// Invalidator is implemented by the stores that can delete groups of
+// entries, as required by Config.InvalidatePrefix and
+// Config.InvalidateTags
+type Invalidator interface {
+	// DeletePrefix removes the entries whose key starts with prefix
+	DeletePrefix(ctx context.Context, prefix string) error
+	// DeleteTags removes the entries with any of the tags
+	DeleteTags(ctx context.Context, tags ...string) error
+}
+
+// hasTag reports whether the entry has any of the tags
+func (e *Entry) hasTag(tags []string) bool {
+	for _, tag := range e.Tags {
+		for _, t := range tags {
+			if tag == t {
+				return true
+			}
+		}
+	}
+	return false
+}
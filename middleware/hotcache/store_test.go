@@ -0,0 +1,172 @@
+package hotcache
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hotcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	if _, err := s.Get(ctx, "GET /"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	e := &Entry{
+		Key:     "GET /",
+		Status:  http.StatusOK,
+		Header:  http.Header{"Content-Type": {"text/plain"}},
+		Body:    []byte("hello"),
+		Vary:    []string{"Accept-Language"},
+		Stored:  now,
+		Expires: now.Add(time.Minute),
+	}
+	if err := s.Set(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, "GET /")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != e.Status || string(got.Body) != "hello" || !reflect.DeepEqual(got.Header, e.Header) ||
+		!reflect.DeepEqual(got.Vary, e.Vary) || !got.Expires.Equal(e.Expires) {
+		t.Errorf("Expected %+v, got %+v", e, got)
+	}
+
+	if err := s.Purge(now.Add(30 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "GET /"); err != nil {
+		t.Errorf("Expected the fresh entry to be kept, got %v", err)
+	}
+	if err := s.Purge(now.Add(2 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "GET /"); err != ErrNotFound {
+		t.Errorf("Expected the expired entry to be purged, got %v", err)
+	}
+
+	s.Set(ctx, e)
+	if err := s.Delete(ctx, "GET /"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "GET /"); err != ErrNotFound {
+		t.Errorf("Expected the entry to be deleted, got %v", err)
+	}
+	if err := s.Delete(ctx, "GET /"); err != nil {
+		t.Errorf("Expected deleting a missing entry to succeed, got %v", err)
+	}
+
+	for _, key := range []string{"GET /a", "GET /a/1", "GET /b"} {
+		s.Set(ctx, &Entry{Key: key, Status: http.StatusOK, Tags: []string{key[5:6]}, Expires: now.Add(time.Minute)})
+	}
+	if err := s.DeletePrefix(ctx, "GET /a/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteTags(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	for key, kept := range map[string]bool{"GET /a": true, "GET /a/1": false, "GET /b": false} {
+		if _, err := s.Get(ctx, key); (err == nil) != kept {
+			t.Errorf("%s: expected kept %v, got %v", key, kept, err)
+		}
+	}
+}
+
+func TestDiskStore_PurgeTemp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hotcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// The modification time of the temporary files of a crashed process
+	// may be the time their entries can be discarded
+	retained := now.Add(24 * time.Hour)
+	files := map[string]bool{
+		".tmp-" + strconv.FormatInt(now.Add(-2*time.Hour).Unix(), 10) + "-1": false,
+		".tmp-" + strconv.FormatInt(now.Unix(), 10) + "-2":                   true,
+	}
+	for name := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, retained, retained)
+	}
+
+	if err := s.Purge(now); err != nil {
+		t.Fatal(err)
+	}
+	for name, kept := range files {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != kept {
+			t.Errorf("%s: expected kept %v, got %v", name, kept, err)
+		}
+	}
+}
+
+func TestConfig_SharedStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hotcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte("hello " + r.Header.Get("Accept-Language")))
+	})
+	replicas := []http.Handler{
+		New(time.Minute).WithStore(store).Middleware(handler),
+		New(time.Minute).WithStore(store).Middleware(handler),
+	}
+	get := func(replica int, lang string) (string, string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		replicas[replica].ServeHTTP(w, req)
+		return w.Body.String(), w.Header().Get("Cache-Status")
+	}
+
+	get(0, "en")
+	if body, status := get(1, "en"); body != "hello en" || !strings.Contains(status, "hit") {
+		t.Errorf("Expected the entry stored by the other replica, got %q %q", body, status)
+	}
+	if body, status := get(1, "es"); body != "hello es" || strings.Contains(status, "hit") {
+		t.Errorf("Expected a miss for another language, got %q %q", body, status)
+	}
+	if body, status := get(0, "es"); body != "hello es" || !strings.Contains(status, "hit") {
+		t.Errorf("Expected a hit for the language stored by the other replica, got %q %q", body, status)
+	}
+	if calls != 2 {
+		t.Errorf("Expected the handler to run once per language, ran %d times", calls)
+	}
+}
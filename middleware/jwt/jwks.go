@@ -0,0 +1,236 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwk is a JSON Web Key, as found in JWKS documents
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := encoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jwk) key() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("jwt: unsupported curve %s", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("jwt: unsupported curve %s", k.Crv)
+		}
+		x, err := encoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, ErrInvalidKeyFormat
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return encoding.DecodeString(k.K)
+	}
+	return nil, fmt.Errorf("jwt: unsupported key type %s", k.Kty)
+}
+
+// ParseJWKS parses a JWKS document into StaticKeys. Keys of unsupported
+// types, or not meant for signatures, are skipped.
+func ParseJWKS(data []byte) (StaticKeys, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	keys := make(StaticKeys)
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.key()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// JWKS is a KeySet loaded from a JWKS document, that is reloaded
+// periodically so that rotated keys are picked up. It does not use
+// futures.Expirable, as it holds its lock while the value is regenerated,
+// which would block every verification during a reload.
+type JWKS struct {
+	location string
+	client   *http.Client
+	refresh  time.Duration
+	// minRefetch is the minimum time between the reloads triggered by
+	// unknown key ids
+	minRefetch time.Duration
+
+	l       sync.Mutex
+	current StaticKeys
+	err     error
+	// fetched is the time the last load started, and loading is closed
+	// when the load in progress, if any, finishes
+	fetched time.Time
+	loading chan struct{}
+	// refetched is the time of the last reload triggered by an unknown
+	// key id
+	refetched time.Time
+}
+
+// NewJWKS creates a KeySet that loads the JWKS document at location,
+// which is an http or https URL or a file path, and reloads it after
+// refresh. The reloads happen in the background while the previous keys
+// are used, and if a reload fails the previous keys are kept.
+//
+// A token with an unknown key id triggers a reload, so that rotated keys
+// are picked up immediately, at most once per minute or per refresh if
+// it is shorter.
+func NewJWKS(location string, refresh time.Duration) *JWKS {
+	minRefetch := time.Minute
+	if refresh < minRefetch {
+		minRefetch = refresh
+	}
+	return &JWKS{
+		location:   location,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		refresh:    refresh,
+		minRefetch: minRefetch,
+	}
+}
+
+func (j *JWKS) fetch() ([]byte, error) {
+	if !strings.HasPrefix(j.location, "http://") && !strings.HasPrefix(j.location, "https://") {
+		return ioutil.ReadFile(j.location)
+	}
+	resp, err := j.client.Get(j.location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwt: fetching %s: %s", j.location, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// reload starts loading the document, unless a load is in progress, and
+// returns a channel closed when it finishes. It must be called with the
+// lock held.
+func (j *JWKS) reload(now time.Time) <-chan struct{} {
+	if j.loading != nil {
+		return j.loading
+	}
+	done := make(chan struct{})
+	j.loading = done
+	j.fetched = now
+	go func() {
+		data, err := j.fetch()
+		var keys StaticKeys
+		if err == nil {
+			keys, err = ParseJWKS(data)
+		}
+		j.l.Lock()
+		j.err = err
+		if err == nil {
+			j.current = keys
+		}
+		j.loading = nil
+		j.l.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+func (j *JWKS) keys() StaticKeys {
+	j.l.Lock()
+	defer j.l.Unlock()
+	return j.current
+}
+
+// Err returns the error of the last load of the document, if any
+func (j *JWKS) Err() error {
+	j.l.Lock()
+	defer j.l.Unlock()
+	return j.err
+}
+
+// Key implements KeySet. It only waits for the document to be loaded if
+// no keys were loaded yet, or if kid is unknown.
+func (j *JWKS) Key(kid, alg string) (interface{}, error) {
+	j.l.Lock()
+	now := time.Now()
+	var wait <-chan struct{}
+	if j.current == nil && (j.loading != nil || now.Sub(j.fetched) >= j.minRefetch) {
+		wait = j.reload(now)
+	} else if now.Sub(j.fetched) >= j.refresh {
+		j.reload(now)
+	}
+	j.l.Unlock()
+	if wait != nil {
+		<-wait
+	}
+
+	key, err := j.keys().Key(kid, alg)
+	if err != ErrUnknownKey {
+		return key, err
+	}
+	// The key may have been rotated before the next refresh
+	j.l.Lock()
+	now = time.Now()
+	if now.Sub(j.refetched) < j.minRefetch {
+		j.l.Unlock()
+		return nil, err
+	}
+	j.refetched = now
+	wait = j.reload(now)
+	j.l.Unlock()
+	<-wait
+	return j.keys().Key(kid, alg)
+}
@@ -0,0 +1,390 @@
+// Package jwt verifies JSON Web Tokens sent as bearer tokens, making
+// their claims available to the handlers.
+//
+// Tokens signed with HS256, RS256, ES256 and EdDSA are supported. The keys
+// can be given directly or loaded from a JWKS document, which is refreshed
+// periodically to follow key rotations.
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/estebarb/ion/middleware"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Supported algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Errors returned when verifying tokens
+var (
+	ErrMalformed        = errors.New("jwt: malformed token")
+	ErrAlgorithm        = errors.New("jwt: unsupported algorithm")
+	ErrSignature        = errors.New("jwt: invalid signature")
+	ErrUnknownKey       = errors.New("jwt: unknown key")
+	ErrExpired          = errors.New("jwt: token is expired")
+	ErrNotYetValid      = errors.New("jwt: token is not valid yet")
+	ErrInvalidIssuer    = errors.New("jwt: invalid issuer")
+	ErrInvalidAudience  = errors.New("jwt: invalid audience")
+	ErrMissingExpiry    = errors.New("jwt: token has no expiration")
+	ErrInvalidKeyFormat = errors.New("jwt: invalid key")
+)
+
+// KeySet provides the keys used to verify tokens
+type KeySet interface {
+	// Key returns the key identified by kid for the algorithm alg. kid may
+	// be empty if the token does not name its key.
+	Key(kid, alg string) (interface{}, error)
+}
+
+// StaticKeys is a KeySet with a fixed set of keys, indexed by their key id.
+// The key with the empty id is used for tokens without a key id. Keys are
+// []byte for HS256, *rsa.PublicKey for RS256, *ecdsa.PublicKey for ES256
+// and ed25519.PublicKey for EdDSA.
+type StaticKeys map[string]interface{}
+
+// Key implements KeySet
+func (s StaticKeys) Key(kid, alg string) (interface{}, error) {
+	key, ok := s[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// Audience is the aud claim, that may be a string or a list of strings
+type Audience []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Contains reports whether aud is in the audience
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// NumericDate is a JWT date, in seconds since the epoch
+type NumericDate float64
+
+// Time returns the date as a time.Time
+func (d NumericDate) Time() time.Time {
+	sec := int64(d)
+	return time.Unix(sec, int64((float64(d)-float64(sec))*1e9))
+}
+
+// Claims are the claims of a verified token
+type Claims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  Audience    `json:"aud,omitempty"`
+	Expiry    NumericDate `json:"exp,omitempty"`
+	NotBefore NumericDate `json:"nbf,omitempty"`
+	IssuedAt  NumericDate `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+	Scope     string      `json:"scope,omitempty"`
+	Roles     []string    `json:"roles,omitempty"`
+
+	raw json.RawMessage
+}
+
+// Decode unmarshals all the claims of the token into v, so that private
+// claims can be read.
+func (c *Claims) Decode(v interface{}) error {
+	return json.Unmarshal(c.raw, v)
+}
+
+// claimsAttribute is the key of the claims in the Principal attributes
+const claimsAttribute = "jwt.claims"
+
+// ClaimsFrom returns the claims of the token that authenticated the request
+func ClaimsFrom(ctx context.Context) (*Claims, bool) {
+	p, ok := middleware.PrincipalFrom(ctx)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := p.Attributes[claimsAttribute].(*Claims)
+	return claims, ok
+}
+
+// Config configures the verification of tokens
+type Config struct {
+	// Keys used to verify the signatures
+	Keys KeySet
+	// Algorithms accepted. All the supported algorithms are accepted if
+	// empty, but a key is only used with the algorithm of its type.
+	Algorithms []string
+	// Issuer, if not empty, must match the iss claim
+	Issuer string
+	// Audience, if not empty, must be included in the aud claim
+	Audience string
+	// Leeway is the clock skew tolerated when checking exp and nbf
+	Leeway time.Duration
+	// RequireExpiry rejects the tokens without an exp claim
+	RequireExpiry bool
+	// Realm sent in the WWW-Authenticate challenges
+	Realm string
+	// Optional lets requests without a token through.
+	// See middleware.RequireAuthentication.
+	Optional bool
+	// Now returns the current time. time.Now is used if nil.
+	Now func() time.Time
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+var encoding = base64.RawURLEncoding
+
+func (c Config) allowed(alg string) bool {
+	if len(c.Algorithms) == 0 {
+		return alg == HS256 || alg == RS256 || alg == ES256 || alg == EdDSA
+	}
+	for _, a := range c.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signature and the claims of the token. The claims are
+// only returned if both are valid.
+func (c Config) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	headerJSON, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var h header
+	if err := json.Unmarshal(headerJSON, &h); err != nil {
+		return nil, ErrMalformed
+	}
+	if !c.allowed(h.Alg) {
+		return nil, ErrAlgorithm
+	}
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	key, err := c.Keys.Key(h.Kid, h.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(h.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	claims := &Claims{raw: payload}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrMalformed
+	}
+	if err := c.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (c Config) validate(claims *Claims) error {
+	now := time.Now()
+	if c.Now != nil {
+		now = c.Now()
+	}
+	if claims.Expiry == 0 && c.RequireExpiry {
+		return ErrMissingExpiry
+	}
+	if claims.Expiry != 0 && !now.Before(claims.Expiry.Time().Add(c.Leeway)) {
+		return ErrExpired
+	}
+	if claims.NotBefore != 0 && now.Add(c.Leeway).Before(claims.NotBefore.Time()) {
+		return ErrNotYetValid
+	}
+	if c.Issuer != "" && claims.Issuer != c.Issuer {
+		return ErrInvalidIssuer
+	}
+	if c.Audience != "" && !claims.Audience.Contains(c.Audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+func verifySignature(alg string, key interface{}, signed, signature []byte) error {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrInvalidKeyFormat
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrSignature
+		}
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidKeyFormat
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return ErrSignature
+		}
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrInvalidKeyFormat
+		}
+		if len(signature) != 64 {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrSignature
+		}
+	case EdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrInvalidKeyFormat
+		}
+		if !ed25519.Verify(pub, signed, signature) {
+			return ErrSignature
+		}
+	default:
+		return ErrAlgorithm
+	}
+	return nil
+}
+
+// Sign creates a token with the given claims, signed with alg. The key
+// is a []byte for HS256, *rsa.PrivateKey for RS256, *ecdsa.PrivateKey for
+// ES256 or ed25519.PrivateKey for EdDSA.
+func Sign(claims interface{}, alg, kid string, key interface{}) (string, error) {
+	headerJSON, err := json.Marshal(header{Alg: alg, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := encoding.EncodeToString(headerJSON) + "." + encoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return "", ErrInvalidKeyFormat
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case RS256:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", ErrInvalidKeyFormat
+		}
+		signature, err = rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest[:])
+	case ES256:
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return "", ErrInvalidKeyFormat
+		}
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, priv, digest[:])
+		if err == nil {
+			signature = make([]byte, 64)
+			r.FillBytes(signature[:32])
+			s.FillBytes(signature[32:])
+		}
+	case EdDSA:
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return "", ErrInvalidKeyFormat
+		}
+		signature = ed25519.Sign(priv, []byte(signed))
+	default:
+		return "", ErrAlgorithm
+	}
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + encoding.EncodeToString(signature), nil
+}
+
+// Principal converts verified claims to a middleware.Principal. The scopes
+// are taken from the space separated scope claim, and the roles from the
+// roles claim.
+func Principal(claims *Claims) *middleware.Principal {
+	return &middleware.Principal{
+		Subject:    claims.Subject,
+		Scheme:     "Bearer",
+		Roles:      claims.Roles,
+		Scopes:     strings.Fields(claims.Scope),
+		Attributes: map[string]interface{}{claimsAttribute: claims},
+	}
+}
+
+// Middleware authenticates the requests with a JWT bearer token. The
+// token claims are available through ClaimsFrom, and the Principal through
+// middleware.PrincipalFrom. Requests with missing or invalid tokens are
+// answered with 401.
+func (c Config) Middleware(next http.Handler) http.Handler {
+	return middleware.BearerAuthConfig{
+		Realm:    c.Realm,
+		Optional: c.Optional,
+		Verify: func(ctx context.Context, token string) (*middleware.Principal, error) {
+			claims, err := c.Verify(token)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", middleware.ErrInvalidCredentials, err)
+			}
+			return Principal(claims), nil
+		},
+	}.Middleware(next)
+}
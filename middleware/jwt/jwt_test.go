@@ -0,0 +1,281 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Expiry    int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Tenant    string   `json:"tenant,omitempty"`
+}
+
+func validClaims() testClaims {
+	return testClaims{
+		Issuer:   "https://issuer.test",
+		Subject:  "frank",
+		Audience: []string{"api"},
+		Expiry:   time.Now().Add(time.Hour).Unix(),
+		Scope:    "read write",
+		Tenant:   "acme",
+	}
+}
+
+func TestVerify_Algorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("secret")
+
+	keys := StaticKeys{
+		"hs": secret,
+		"rs": &rsaKey.PublicKey,
+		"es": &ecKey.PublicKey,
+		"ed": edPub,
+	}
+	signing := map[string]struct {
+		alg string
+		key interface{}
+	}{
+		"hs": {HS256, secret},
+		"rs": {RS256, rsaKey},
+		"es": {ES256, ecKey},
+		"ed": {EdDSA, edPriv},
+	}
+	config := Config{Keys: keys, Issuer: "https://issuer.test", Audience: "api"}
+
+	for kid, s := range signing {
+		token, err := Sign(validClaims(), s.alg, kid, s.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := config.Verify(token)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", s.alg, err)
+			continue
+		}
+		if claims.Subject != "frank" {
+			t.Errorf("%s: unexpected subject %q", s.alg, claims.Subject)
+		}
+
+		// Tamper the payload
+		other := validClaims()
+		other.Subject = "mallory"
+		forged, _ := Sign(other, s.alg, kid, s.key)
+		tampered := token[:len(token)-len(signatureOf(token))] + signatureOf(forged)
+		if _, err := config.Verify(tampered); err != ErrSignature {
+			t.Errorf("%s: expected ErrSignature, got %v", s.alg, err)
+		}
+	}
+
+	// A token signed with HS256 using the RSA public key must be rejected
+	token, _ := Sign(validClaims(), HS256, "rs", []byte("anything"))
+	if _, err := config.Verify(token); err != ErrInvalidKeyFormat {
+		t.Errorf("Expected ErrInvalidKeyFormat, got %v", err)
+	}
+}
+
+func signatureOf(token string) string {
+	for i := len(token) - 1; i >= 0; i-- {
+		if token[i] == '.' {
+			return token[i+1:]
+		}
+	}
+	return ""
+}
+
+func TestVerify_Claims(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	config := Config{
+		Keys:     StaticKeys{"": secret},
+		Issuer:   "https://issuer.test",
+		Audience: "api",
+		Leeway:   time.Minute,
+	}
+	cases := []struct {
+		modify func(c *testClaims)
+		err    error
+	}{
+		{func(c *testClaims) {}, nil},
+		{func(c *testClaims) { c.Expiry = now.Add(-30 * time.Second).Unix() }, nil},
+		{func(c *testClaims) { c.Expiry = now.Add(-2 * time.Minute).Unix() }, ErrExpired},
+		{func(c *testClaims) { c.NotBefore = now.Add(30 * time.Second).Unix() }, nil},
+		{func(c *testClaims) { c.NotBefore = now.Add(2 * time.Minute).Unix() }, ErrNotYetValid},
+		{func(c *testClaims) { c.Issuer = "https://other.test" }, ErrInvalidIssuer},
+		{func(c *testClaims) { c.Audience = []string{"web"} }, ErrInvalidAudience},
+	}
+	for i, c := range cases {
+		claims := validClaims()
+		c.modify(&claims)
+		token, _ := Sign(claims, HS256, "", secret)
+		if _, err := config.Verify(token); err != c.err {
+			t.Errorf("Case %d: expected %v, got %v", i, c.err, err)
+		}
+	}
+
+	config.Algorithms = []string{RS256}
+	token, _ := Sign(validClaims(), HS256, "", secret)
+	if _, err := config.Verify(token); err != ErrAlgorithm {
+		t.Errorf("Expected ErrAlgorithm, got %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	secret := []byte("secret")
+	h := Config{Keys: StaticKeys{"": secret}}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFrom(r.Context())
+		if !ok {
+			t.Fatal("Expected claims in the context")
+		}
+		var private struct{ Tenant string }
+		claims.Decode(&private)
+		fmt.Fprintf(w, "%s %s %v", claims.Subject, private.Tenant, claims.Audience)
+	}))
+
+	token, _ := Sign(validClaims(), HS256, "", secret)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Body.String() != "frank acme [api]" {
+		t.Errorf("Unexpected response %d %q", w.Code, w.Body.String())
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token+"x")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", w.Code)
+	}
+}
+
+func ecJWK(kid string, pub *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"kid": kid,
+		"x":   encoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+		"y":   encoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func TestJWKS_Rotation(t *testing.T) {
+	first, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	second, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var rotated int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := []map[string]string{{
+			"kty": "RSA",
+			"kid": "rsa",
+			"n":   encoding.EncodeToString(rsaKey.N.Bytes()),
+			"e":   encoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		}}
+		if atomic.LoadInt32(&rotated) == 0 {
+			keys = append(keys, ecJWK("first", &first.PublicKey))
+		} else {
+			keys = append(keys, ecJWK("second", &second.PublicKey))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer ts.Close()
+
+	config := Config{Keys: NewJWKS(ts.URL, 50*time.Millisecond)}
+	token, _ := Sign(validClaims(), ES256, "first", first)
+	if _, err := config.Verify(token); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	token, _ = Sign(validClaims(), RS256, "rsa", rsaKey)
+	if _, err := config.Verify(token); err != nil {
+		t.Errorf("Unexpected error with the RSA key %v", err)
+	}
+
+	atomic.StoreInt32(&rotated, 1)
+	token, _ = Sign(validClaims(), ES256, "second", second)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := config.Verify(token); err != nil {
+		t.Errorf("Expected the rotated key to be loaded, got %v", err)
+	}
+}
+
+func TestJWKS_UnknownKey(t *testing.T) {
+	first, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	second, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var fetches, rotated int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		key := ecJWK("first", &first.PublicKey)
+		if atomic.LoadInt32(&rotated) == 1 {
+			key = ecJWK("second", &second.PublicKey)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{key}})
+	}))
+	defer ts.Close()
+
+	config := Config{Keys: NewJWKS(ts.URL, time.Hour)}
+	token, _ := Sign(validClaims(), ES256, "first", first)
+	if _, err := config.Verify(token); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	atomic.StoreInt32(&rotated, 1)
+	token, _ = Sign(validClaims(), ES256, "second", second)
+	if _, err := config.Verify(token); err != nil {
+		t.Errorf("Expected the unknown key to trigger a reload, got %v", err)
+	}
+	token, _ = Sign(validClaims(), ES256, "other", second)
+	if claims, err := config.Verify(token); err != ErrUnknownKey || claims != nil {
+		t.Errorf("Expected ErrUnknownKey without claims, got %v %v", claims, err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("Expected the reloads for unknown keys to be rate limited, got %d fetches", n)
+	}
+}
+
+func TestJWKS_BackgroundReload(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var slow int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&slow) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{ecJWK("key", &key.PublicKey)}})
+	}))
+	defer ts.Close()
+
+	config := Config{Keys: NewJWKS(ts.URL, 20*time.Millisecond)}
+	token, _ := Sign(validClaims(), ES256, "key", key)
+	if _, err := config.Verify(token); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	// The scheduled reloads do not block the verification
+	atomic.StoreInt32(&slow, 1)
+	time.Sleep(30 * time.Millisecond)
+	start := time.Now()
+	if _, err := config.Verify(token); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Expected the previous keys to be used while reloading, took %v", d)
+	}
+}
@@ -2,46 +2,54 @@
 package middleware
 
 import (
-	"log"
+	"github.com/estebarb/ion"
 	"net/http"
 	"time"
 )
 
-// Logging provides a logging middleware
+// Logging provides a logging middleware. Every request is logged through the
+// Logger returned by ion.LoggerFrom, with its method, path, route pattern,
+// status, bytes written, duration and request id.
 func Logging(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		t1 := time.Now()
-		next.ServeHTTP(w, r)
+		rw := WrapResponseWriter(w)
+		r = ion.TrackRoute(r)
+		next.ServeHTTP(rw, r)
 		t2 := time.Now()
-		log.Printf("[%s] %q %v\n", r.Method, r.URL.String(), t2.Sub(t1))
+		status := rw.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ion.LoggerFrom(r.Context()).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", ion.RoutePattern(r.Context()),
+			"status", status,
+			"bytes", rw.BytesWritten(),
+			"duration", t2.Sub(t1),
+			"request_id", RequestIDFrom(r.Context()))
 	}
 
 	return http.HandlerFunc(fn)
 }
 
-// DontPanic recovers from panics in other handlers
+// DontPanic recovers from panics in other handlers, answering with
+// a 500 error. See PanicConfig for more options.
 func DontPanic(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				log.Printf("panic: %+v", err)
-				http.Error(w, http.StatusText(500), 500)
-			}
-		}()
-
-		next.ServeHTTP(w, r)
-	}
-
-	return http.HandlerFunc(fn)
+	return PanicConfig{}.Middleware(next)
 }
 
 // FormParser parses the forms in all the requests,
 // so that you don't have to do it in the handlers/controllers.
+// Malformed forms are answered with 400. See BodyConfig for multipart
+// and JSON bodies.
 func FormParser(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		err := r.ParseForm()
 		if err != nil {
-			panic(err)
+			ion.WriteError(w, r, http.StatusBadRequest, err.Error(), "")
+			return
 		}
 		next.ServeHTTP(w, r)
 	}
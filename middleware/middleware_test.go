@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/estebarb/ion"
+	"github.com/estebarb/ion/components/router"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type logEntry struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+// testLogger records the messages it receives
+type testLogger struct {
+	sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) log(level, msg string, args []interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(args); i += 2 {
+		fields[fmt.Sprint(args[i])] = args[i+1]
+	}
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log("DEBUG", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("INFO", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("WARN", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args) }
+
+func TestLogging(t *testing.T) {
+	logger := &testLogger{}
+	r := router.New()
+	r.GetFunc("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+	h := ion.Chain{ion.WithLogger(logger), RequestID, Logging}.Then(r)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(logger.entries) != 1 {
+		t.Fatalf("Expected 1 log entry, got %d", len(logger.entries))
+	}
+	entry := logger.entries[0]
+	expected := map[string]interface{}{
+		"method":     http.MethodGet,
+		"path":       "/users/42",
+		"route":      "/users/:id",
+		"status":     http.StatusCreated,
+		"bytes":      5,
+		"request_id": "abc",
+	}
+	for k, v := range expected {
+		if entry.fields[k] != v {
+			t.Errorf("Expected %s=%v, got %v", k, v, entry.fields[k])
+		}
+	}
+	if _, ok := entry.fields["duration"]; !ok {
+		t.Error("Expected a duration field")
+	}
+}
+
+func TestDontPanic(t *testing.T) {
+	logger := &testLogger{}
+	h := ion.Chain{ion.WithLogger(logger), DontPanic}.Then(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", w.Code)
+	}
+	if len(logger.entries) != 1 || logger.entries[0].level != "ERROR" {
+		t.Errorf("Expected an error to be logged, got %v", logger.entries)
+	}
+}
+
+func dummyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("hello"))
+}
@@ -0,0 +1,273 @@
+package middleware
+
+import (
+	"encoding/binary"
+	"github.com/estebarb/ion"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitResult is the decision of a Limiter about a request
+type RateLimitResult struct {
+	// Allowed reports whether the request may proceed
+	Allowed bool
+	// Limit is the number of requests allowed in a window
+	Limit int
+	// Remaining is the number of requests left in the current window
+	Remaining int
+	// Reset is the time until the quota is fully restored
+	Reset time.Duration
+	// RetryAfter is the time until the next request would be allowed,
+	// when the request is not allowed
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether the requests identified by key may proceed
+type Limiter interface {
+	Allow(key string) (RateLimitResult, error)
+}
+
+// RateLimitStore keeps the state of limiters, for example in memory or
+// in an external database shared between replicas.
+type RateLimitStore interface {
+	// Update atomically replaces the state of key by the result of fn,
+	// which receives the current state or nil if there is none. The state
+	// may be discarded after ttl without updates.
+	Update(key string, ttl time.Duration, fn func(state []byte) []byte) error
+}
+
+// RateLimitMemoryStore is a RateLimitStore that keeps the state in memory.
+// The zero value is ready to use.
+type RateLimitMemoryStore struct {
+	l       sync.Mutex
+	entries map[string]rateLimitEntry
+	updates int
+}
+
+type rateLimitEntry struct {
+	state   []byte
+	expires time.Time
+}
+
+// NewRateLimitMemoryStore creates a new RateLimitMemoryStore
+func NewRateLimitMemoryStore() *RateLimitMemoryStore {
+	return &RateLimitMemoryStore{}
+}
+
+// Update implements RateLimitStore
+func (s *RateLimitMemoryStore) Update(key string, ttl time.Duration, fn func(state []byte) []byte) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	now := time.Now()
+	if s.entries == nil {
+		s.entries = make(map[string]rateLimitEntry)
+	}
+	entry, ok := s.entries[key]
+	if ok && now.After(entry.expires) {
+		entry.state = nil
+	}
+	s.entries[key] = rateLimitEntry{
+		state:   fn(entry.state),
+		expires: now.Add(ttl),
+	}
+
+	// Purge the expired entries from time to time
+	s.updates++
+	if s.updates >= 1024 && s.updates >= len(s.entries) {
+		s.updates = 0
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	return nil
+}
+
+// TokenBucket is a Limiter that allows bursts of up to Capacity requests,
+// restoring one request every Interval.
+type TokenBucket struct {
+	Store    RateLimitStore
+	Capacity int
+	Interval time.Duration
+}
+
+// Allow implements Limiter
+func (b TokenBucket) Allow(key string) (RateLimitResult, error) {
+	result := RateLimitResult{Limit: b.Capacity}
+	full := time.Duration(b.Capacity) * b.Interval
+	err := b.Store.Update(key, full, func(state []byte) []byte {
+		now := time.Now().UnixNano()
+		tokens := float64(b.Capacity)
+		if len(state) == 16 {
+			last := int64(binary.BigEndian.Uint64(state[0:8]))
+			tokens = math.Float64frombits(binary.BigEndian.Uint64(state[8:16]))
+			tokens += float64(now-last) / float64(b.Interval)
+			if tokens > float64(b.Capacity) {
+				tokens = float64(b.Capacity)
+			}
+		}
+		if tokens >= 1 {
+			tokens--
+			result.Allowed = true
+		} else {
+			result.RetryAfter = time.Duration((1 - tokens) * float64(b.Interval))
+		}
+		result.Remaining = int(tokens)
+		result.Reset = time.Duration((float64(b.Capacity) - tokens) * float64(b.Interval))
+
+		state = make([]byte, 16)
+		binary.BigEndian.PutUint64(state[0:8], uint64(now))
+		binary.BigEndian.PutUint64(state[8:16], math.Float64bits(tokens))
+		return state
+	})
+	return result, err
+}
+
+// SlidingWindow is a Limiter that allows Limit requests in any period of
+// length Window. It approximates the window by weighting the count of the
+// previous fixed window.
+type SlidingWindow struct {
+	Store  RateLimitStore
+	Limit  int
+	Window time.Duration
+}
+
+// Allow implements Limiter
+func (s SlidingWindow) Allow(key string) (RateLimitResult, error) {
+	result := RateLimitResult{Limit: s.Limit}
+	err := s.Store.Update(key, 2*s.Window, func(state []byte) []byte {
+		now := time.Now().UnixNano()
+		window := int64(s.Window)
+		start := now - now%window
+		var previous, current int64
+		if len(state) == 24 {
+			stateStart := int64(binary.BigEndian.Uint64(state[0:8]))
+			switch stateStart {
+			case start:
+				previous = int64(binary.BigEndian.Uint64(state[8:16]))
+				current = int64(binary.BigEndian.Uint64(state[16:24]))
+			case start - window:
+				previous = int64(binary.BigEndian.Uint64(state[16:24]))
+			}
+		}
+		elapsed := float64(now-start) / float64(window)
+		count := float64(previous)*(1-elapsed) + float64(current)
+		if count+1 <= float64(s.Limit) {
+			current++
+			count++
+			result.Allowed = true
+		} else if previous > 0 {
+			// Wait until enough of the previous window slides out
+			needed := (count + 1 - float64(s.Limit)) / float64(previous)
+			result.RetryAfter = time.Duration(needed * float64(window))
+		} else {
+			result.RetryAfter = time.Duration(start + window - now)
+		}
+		result.Remaining = s.Limit - int(math.Ceil(count))
+		if result.Remaining < 0 {
+			result.Remaining = 0
+		}
+		result.Reset = time.Duration(start + window - now)
+		if current > 0 {
+			result.Reset += s.Window
+		}
+
+		state = make([]byte, 24)
+		binary.BigEndian.PutUint64(state[0:8], uint64(start))
+		binary.BigEndian.PutUint64(state[8:16], uint64(previous))
+		binary.BigEndian.PutUint64(state[16:24], uint64(current))
+		return state
+	})
+	return result, err
+}
+
+// KeyByIP identifies the clients by their IP address
+func KeyByIP(r *http.Request) string {
+	return "ip:" + remoteHost(r)
+}
+
+// KeyByHeader returns a function that identifies the clients by the value
+// of the given header, falling back to the IP address if it is missing.
+func KeyByHeader(name string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if value := r.Header.Get(name); value != "" {
+			return "header:" + name + ":" + value
+		}
+		return KeyByIP(r)
+	}
+}
+
+// KeyByUser identifies the clients by the authenticated Principal, falling
+// back to the IP address for anonymous requests. The middleware must be
+// applied after the authentication middleware.
+func KeyByUser(r *http.Request) string {
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		return "user:" + p.Subject
+	}
+	return KeyByIP(r)
+}
+
+// KeyByRoute returns a function that limits the requests to each named
+// route separately, combined with the given key function. The middleware
+// must be applied to the route, so that the route is known.
+func KeyByRoute(key func(r *http.Request) string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return "route:" + ion.RouteName(r.Context()) + ":" + key(r)
+	}
+}
+
+// RateLimitConfig configures the rate limiting of requests
+type RateLimitConfig struct {
+	// Limiter decides whether the requests may proceed
+	Limiter Limiter
+	// Key identifies the client of a request. KeyByIP is used if nil.
+	Key func(r *http.Request) string
+	// OnLimited, if not nil, handles the requests that exceed the limit.
+	// By default they are answered with 429.
+	OnLimited http.Handler
+}
+
+// Middleware limits the rate of requests of each client. The quota is
+// reported with the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, and the requests that exceed it are answered with 429 and a
+// Retry-After header. If the Limiter fails the request is allowed and the
+// error is logged.
+func (c RateLimitConfig) Middleware(next http.Handler) http.Handler {
+	key := c.Key
+	if key == nil {
+		key = KeyByIP
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		result, err := c.Limiter.Allow(key(r))
+		if err != nil {
+			ion.LoggerFrom(r.Context()).Error("rate limit",
+				"error", err.Error(),
+				"request_id", RequestIDFrom(r.Context()))
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if result.Allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		if c.OnLimited != nil {
+			c.OnLimited.ServeHTTP(w, r)
+			return
+		}
+		ion.WriteError(w, r, http.StatusTooManyRequests, "rate limit exceeded", "")
+	}
+	return http.HandlerFunc(fn)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
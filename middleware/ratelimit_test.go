@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/estebarb/ion/components/router"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func limitedRequest(h http.Handler, path, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimit_TokenBucket(t *testing.T) {
+	h := RateLimitConfig{
+		Limiter: TokenBucket{Store: NewRateLimitMemoryStore(), Capacity: 2, Interval: time.Minute},
+	}.Middleware(http.HandlerFunc(dummyHandler))
+
+	for i := 0; i < 2; i++ {
+		w := limitedRequest(h, "/", "10.0.0.1")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to be allowed, got %d", i, w.Code)
+		}
+		if w.Header().Get("RateLimit-Limit") != "2" {
+			t.Errorf("Expected RateLimit-Limit: 2, got %v", w.Header())
+		}
+	}
+
+	w := limitedRequest(h, "/", "10.0.0.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "60" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Unexpected headers %v", w.Header())
+	}
+
+	w = limitedRequest(h, "/", "10.0.0.2")
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected another client to be allowed, got %d", w.Code)
+	}
+}
+
+func TestRateLimit_SlidingWindow(t *testing.T) {
+	limiter := SlidingWindow{Store: NewRateLimitMemoryStore(), Limit: 3, Window: 50 * time.Millisecond}
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		result, err := limiter.Allow("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed {
+			allowed++
+		} else if result.RetryAfter <= 0 {
+			t.Errorf("Expected a positive RetryAfter, got %v", result.RetryAfter)
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("Expected 3 allowed requests, got %d", allowed)
+	}
+
+	time.Sleep(110 * time.Millisecond)
+	result, _ := limiter.Allow("key")
+	if !result.Allowed {
+		t.Error("Expected the window to slide")
+	}
+}
+
+func TestRateLimit_KeyByRoute(t *testing.T) {
+	limit := RateLimitConfig{
+		Limiter: TokenBucket{Store: NewRateLimitMemoryStore(), Capacity: 1, Interval: time.Minute},
+		Key:     KeyByRoute(KeyByIP),
+	}
+	r := router.New()
+	r.Get("/a", limit.Middleware(http.HandlerFunc(dummyHandler))).Name("a")
+	r.Get("/b", limit.Middleware(http.HandlerFunc(dummyHandler))).Name("b")
+
+	if w := limitedRequest(r, "/a", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", w.Code)
+	}
+	if w := limitedRequest(r, "/b", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("Expected each route to have its own quota, got %d", w.Code)
+	}
+	if w := limitedRequest(r, "/a", "10.0.0.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429, got %d", w.Code)
+	}
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(key string) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store unavailable")
+}
+
+func TestRateLimit_FailOpen(t *testing.T) {
+	h := quiet(RateLimitConfig{Limiter: failingLimiter{}}.Middleware(http.HandlerFunc(dummyHandler)))
+	if w := limitedRequest(h, "/", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("Expected the request to be allowed when the limiter fails, got %d", w.Code)
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/estebarb/ion"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicReporter is called with every panic recovered by PanicConfig,
+// for example to forward it to an error tracking service.
+type PanicReporter func(r *http.Request, recovered interface{}, stack []byte)
+
+// PanicConfig configures the recovery of panics in handlers
+type PanicConfig struct {
+	// Reporter, if not nil, is called after logging the panic
+	Reporter PanicReporter
+	// Development renders the panic and its stack trace in the error
+	// response. It must not be enabled in production.
+	Development bool
+}
+
+// Middleware recovers from panics in the wrapped handler. The panic is
+// logged with its stack trace through ion.LoggerFrom and passed to the
+// Reporter. If the response has not been started a 500 error is written
+// in the format requested by the client, otherwise it is aborted with
+// http.ErrAbortHandler so that the client sees it is incomplete.
+//
+// Panics with http.ErrAbortHandler are propagated, so that net/http aborts
+// the response as the handler intended.
+func (c PanicConfig) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		rw := WrapResponseWriter(w)
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			stack := debug.Stack()
+			ion.LoggerFrom(r.Context()).Error("panic",
+				"error", fmt.Sprintf("%+v", err),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"request_id", RequestIDFrom(r.Context()),
+				"stack", string(stack))
+			if c.Reporter != nil {
+				c.Reporter(r, err, stack)
+			}
+			if rw.Written() {
+				// The status was already sent, so abort the response
+				// to tell the client that it is incomplete
+				panic(http.ErrAbortHandler)
+			}
+			var message, detail string
+			if c.Development {
+				message = fmt.Sprintf("panic: %+v", err)
+				detail = string(stack)
+			}
+			ion.WriteError(rw, r, http.StatusInternalServerError, message, detail)
+		}()
+
+		next.ServeHTTP(rw, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
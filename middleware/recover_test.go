@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"encoding/json"
+	"github.com/estebarb/ion"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func panicking(w http.ResponseWriter, r *http.Request) {
+	panic("boom")
+}
+
+func quiet(h http.Handler) http.Handler {
+	return ion.WithLogger(ion.DiscardLogger)(h)
+}
+
+func TestPanicConfig_Negotiation(t *testing.T) {
+	h := quiet(PanicConfig{}.Middleware(http.HandlerFunc(panicking)))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", w.Code)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["status"] != float64(500) {
+		t.Errorf("Unexpected body %v", body)
+	}
+	if _, ok := body["detail"]; ok {
+		t.Error("The stack must not be sent outside development mode")
+	}
+
+	req.Header.Set("Accept", "text/html,*/*;q=0.8")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Expected HTML, got %s", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestPanicConfig_Development(t *testing.T) {
+	var reported interface{}
+	var stack []byte
+	h := quiet(PanicConfig{
+		Development: true,
+		Reporter: func(r *http.Request, recovered interface{}, s []byte) {
+			reported, stack = recovered, s
+		},
+	}.Middleware(http.HandlerFunc(panicking)))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if reported != "boom" || len(stack) == 0 {
+		t.Errorf("Expected the panic to be reported, got %v", reported)
+	}
+	if !strings.Contains(w.Body.String(), "panicking") {
+		t.Errorf("Expected the stack in the body, got %q", w.Body.String())
+	}
+}
+
+func TestPanicConfig_AlreadyWritten(t *testing.T) {
+	h := quiet(PanicConfig{}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		panic("boom")
+	})))
+	w := httptest.NewRecorder()
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("Expected the response to be aborted, got %v", err)
+		}
+		if w.Code != http.StatusAccepted || w.Body.String() != "partial" {
+			t.Errorf("Expected the partial response untouched, got %d %q", w.Code, w.Body.String())
+		}
+	}()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestPanicConfig_ErrAbortHandler(t *testing.T) {
+	h := PanicConfig{}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("Expected http.ErrAbortHandler to be propagated, got %v", err)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
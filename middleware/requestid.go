@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// DefaultRequestIDHeader is the header used by RequestIDConfig if
+// none is given
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength limits the size of the identifiers accepted from clients
+const maxRequestIDLength = 128
+
+// RequestIDConfig configures the assignation of request identifiers
+type RequestIDConfig struct {
+	// Header is read to accept the identifier assigned by the client or
+	// a proxy, and written in the response. DefaultRequestIDHeader is
+	// used if empty.
+	Header string
+	// Generator creates new identifiers. NewULID is used if nil.
+	Generator func() string
+	// IgnoreIncoming makes the middleware always generate a new identifier
+	IgnoreIncoming bool
+}
+
+type requestIDKey struct{}
+
+// RequestIDFrom returns the identifier assigned to the request by the
+// RequestID middleware, or an empty string if it has none.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID assigns an identifier to every request, using the default
+// RequestIDConfig. It must wrap Logging, AccessLog and DontPanic for them
+// to log the identifier.
+func RequestID(next http.Handler) http.Handler {
+	return RequestIDConfig{}.Middleware(next)
+}
+
+// Middleware assigns an identifier to every request. The identifier is
+// taken from the request header if present and valid, or generated
+// otherwise. It is stored in the context, available through RequestIDFrom,
+// and echoed in the response header.
+func (c RequestIDConfig) Middleware(next http.Handler) http.Handler {
+	header := c.Header
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+	generator := c.Generator
+	if generator == nil {
+		generator = NewULID
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var id string
+		if !c.IgnoreIncoming {
+			id = r.Header.Get(header)
+		}
+		if !validRequestID(id) {
+			id = generator()
+		}
+		w.Header().Set(header, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewUUID returns a random (version 4) UUID
+func NewUUID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID for the current time. ULIDs are sortable by
+// creation time, with a millisecond resolution.
+func NewULID() string {
+	var u [16]byte
+	binary.BigEndian.PutUint64(u[0:8], uint64(time.Now().UnixNano()/int64(time.Millisecond))<<16)
+	rand.Read(u[6:])
+
+	// 128 bits encoded as 26 characters of 5 bits, the first one
+	// only takes 3 bits
+	hi := binary.BigEndian.Uint64(u[0:8])
+	lo := binary.BigEndian.Uint64(u[8:16])
+	var buf [26]byte
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var id string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = RequestIDFrom(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(id) != 26 {
+		t.Errorf("Expected a generated ULID, got %q", id)
+	}
+	if w.Header().Get(DefaultRequestIDHeader) != id {
+		t.Errorf("Expected the id echoed in the response, got %q", w.Header().Get(DefaultRequestIDHeader))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(DefaultRequestIDHeader, "from-proxy")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if id != "from-proxy" {
+		t.Errorf("Expected the incoming id, got %q", id)
+	}
+
+	req.Header.Set(DefaultRequestIDHeader, "invalid id\n")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if id == "invalid id\n" {
+		t.Error("Expected an invalid incoming id to be replaced")
+	}
+}
+
+func TestRequestIDConfig(t *testing.T) {
+	var id string
+	h := RequestIDConfig{
+		Header:         "X-Correlation-Id",
+		Generator:      NewUUID,
+		IgnoreIncoming: true,
+	}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = RequestIDFrom(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Correlation-Id", "from-client")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuid.MatchString(id) {
+		t.Errorf("Expected a UUID, got %q", id)
+	}
+	if w.Header().Get("X-Correlation-Id") != id {
+		t.Errorf("Expected the id echoed in the response")
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	a := NewULID()
+	ulid := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	if !ulid.MatchString(a) {
+		t.Errorf("Invalid ULID %q", a)
+	}
+	if b := NewULID(); b == a {
+		t.Errorf("Expected different ULIDs, got %q twice", a)
+	}
+}
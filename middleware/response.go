@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// ResponseWriter is an http.ResponseWriter that records the status code
+// and the number of bytes written to the response.
+type ResponseWriter interface {
+	http.ResponseWriter
+	// Status returns the status code sent, or 0 if the headers have not
+	// been written yet
+	Status() int
+	// BytesWritten returns the number of bytes of the body written so far
+	BytesWritten() int
+	// Written reports whether the headers were already sent
+	Written() bool
+	// Unwrap returns the original http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+// WrapResponseWriter wraps w in a ResponseWriter. The returned value
+// implements http.Flusher, http.Hijacker and http.Pusher only if w does.
+// If w is already a ResponseWriter it is returned unchanged.
+func WrapResponseWriter(w http.ResponseWriter) ResponseWriter {
+	if rw, ok := w.(ResponseWriter); ok {
+		return rw
+	}
+	rw := &responseWriter{ResponseWriter: w}
+	_, fl := w.(http.Flusher)
+	_, hj := w.(http.Hijacker)
+	_, pu := w.(http.Pusher)
+	switch {
+	case fl && hj && pu:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{rw, flushWriter{rw}, hijackWriter{rw}, pushWriter{rw}}
+	case fl && hj:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+		}{rw, flushWriter{rw}, hijackWriter{rw}}
+	case fl && pu:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Pusher
+		}{rw, flushWriter{rw}, pushWriter{rw}}
+	case hj && pu:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.Pusher
+		}{rw, hijackWriter{rw}, pushWriter{rw}}
+	case fl:
+		return struct {
+			*responseWriter
+			http.Flusher
+		}{rw, flushWriter{rw}}
+	case hj:
+		return struct {
+			*responseWriter
+			http.Hijacker
+		}{rw, hijackWriter{rw}}
+	case pu:
+		return struct {
+			*responseWriter
+			http.Pusher
+		}{rw, pushWriter{rw}}
+	}
+	return rw
+}
+
+// responseWriter records the status code and the number of bytes written
+// through an http.ResponseWriter
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *responseWriter) Status() int {
+	return w.status
+}
+
+func (w *responseWriter) BytesWritten() int {
+	return w.bytes
+}
+
+func (w *responseWriter) Written() bool {
+	return w.status != 0
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type flushWriter struct {
+	*responseWriter
+}
+
+func (w flushWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+type hijackWriter struct {
+	*responseWriter
+}
+
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+type pushWriter struct {
+	*responseWriter
+}
+
+func (w pushWriter) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
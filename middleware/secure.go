@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/estebarb/ion"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSPNonceSource is a CSP source that is replaced by a nonce generated for
+// each request, like 'nonce-Tm9uY2U'. The nonce is available to templates
+// through CSPNonce.
+const CSPNonceSource = "'nonce'"
+
+// CSP is a Content-Security-Policy, that maps directives to their sources
+type CSP map[string][]string
+
+// With returns a copy of the policy with the sources added to directive
+func (p CSP) With(directive string, sources ...string) CSP {
+	c := p.clone()
+	c[directive] = append(append([]string{}, c[directive]...), sources...)
+	return c
+}
+
+// Without returns a copy of the policy without directive
+func (p CSP) Without(directive string) CSP {
+	c := p.clone()
+	delete(c, directive)
+	return c
+}
+
+func (p CSP) clone() CSP {
+	c := make(CSP, len(p)+1)
+	for k, v := range p {
+		c[k] = v
+	}
+	return c
+}
+
+// Build returns the value of the header, with the directives sorted and
+// CSPNonceSource replaced by the given nonce
+func (p CSP) Build(nonce string) string {
+	directives := make([]string, 0, len(p))
+	for d := range p {
+		directives = append(directives, d)
+	}
+	sort.Strings(directives)
+	var b strings.Builder
+	for i, d := range directives {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(d)
+		for _, source := range p[d] {
+			if source == CSPNonceSource {
+				source = "'nonce-" + nonce + "'"
+			}
+			b.WriteString(" ")
+			b.WriteString(source)
+		}
+	}
+	return b.String()
+}
+
+// SecureHeadersConfig configures the security headers of the responses.
+// The headers with empty or zero values are not sent.
+type SecureHeadersConfig struct {
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// NoSniff sends X-Content-Type-Options: nosniff
+	NoSniff bool
+	// FrameOptions is the X-Frame-Options header, like "DENY" or
+	// "SAMEORIGIN"
+	FrameOptions string
+	// ReferrerPolicy is the Referrer-Policy header
+	ReferrerPolicy string
+	// PermissionsPolicy is the Permissions-Policy header
+	PermissionsPolicy string
+	// CrossOriginOpenerPolicy is the Cross-Origin-Opener-Policy header
+	CrossOriginOpenerPolicy string
+	// CSP is the Content-Security-Policy
+	CSP CSP
+	// CSPReportOnly sends the CSP in the
+	// Content-Security-Policy-Report-Only header, so that violations are
+	// reported but not blocked
+	CSPReportOnly bool
+}
+
+// DefaultSecureHeaders is the configuration used by SecureHeaders. Its
+// CSP only allows resources from the same origin, and inline scripts and
+// styles with the request nonce.
+var DefaultSecureHeaders = SecureHeadersConfig{
+	HSTSMaxAge:              2 * 365 * 24 * time.Hour,
+	HSTSIncludeSubdomains:   true,
+	NoSniff:                 true,
+	FrameOptions:            "DENY",
+	ReferrerPolicy:          "strict-origin-when-cross-origin",
+	CrossOriginOpenerPolicy: "same-origin",
+	CSP: CSP{
+		"default-src":     {"'self'"},
+		"script-src":      {"'self'", CSPNonceSource},
+		"style-src":       {"'self'", CSPNonceSource},
+		"object-src":      {"'none'"},
+		"base-uri":        {"'self'"},
+		"frame-ancestors": {"'none'"},
+	},
+}
+
+// secureHeaderNames are the headers managed by SecureHeadersConfig
+var secureHeaderNames = []string{
+	"Strict-Transport-Security",
+	"X-Content-Type-Options",
+	"X-Frame-Options",
+	"Referrer-Policy",
+	"Permissions-Policy",
+	"Cross-Origin-Opener-Policy",
+	"Content-Security-Policy",
+	"Content-Security-Policy-Report-Only",
+}
+
+func (c SecureHeadersConfig) apply(h http.Header, nonce string) {
+	for _, name := range secureHeaderNames {
+		h.Del(name)
+	}
+	if c.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.FormatInt(int64(c.HSTSMaxAge/time.Second), 10)
+		if c.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if c.HSTSPreload {
+			hsts += "; preload"
+		}
+		h.Set("Strict-Transport-Security", hsts)
+	}
+	if c.NoSniff {
+		h.Set("X-Content-Type-Options", "nosniff")
+	}
+	set := func(name, value string) {
+		if value != "" {
+			h.Set(name, value)
+		}
+	}
+	set("X-Frame-Options", c.FrameOptions)
+	set("Referrer-Policy", c.ReferrerPolicy)
+	set("Permissions-Policy", c.PermissionsPolicy)
+	set("Cross-Origin-Opener-Policy", c.CrossOriginOpenerPolicy)
+	if len(c.CSP) > 0 {
+		if c.CSPReportOnly {
+			h.Set("Content-Security-Policy-Report-Only", c.CSP.Build(nonce))
+		} else {
+			h.Set("Content-Security-Policy", c.CSP.Build(nonce))
+		}
+	}
+}
+
+type secureHeadersKey struct{}
+
+// secureHeadersState is the configuration applied to a request, and
+// its nonce
+type secureHeadersState struct {
+	config SecureHeadersConfig
+	nonce  string
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+// CSPNonce returns the nonce of the request, to be used in the nonce
+// attribute of inline scripts and styles:
+//
+//	<script nonce="{{.Nonce}}">...</script>
+func CSPNonce(ctx context.Context) string {
+	state, _ := ctx.Value(secureHeadersKey{}).(*secureHeadersState)
+	if state == nil {
+		return ""
+	}
+	return state.nonce
+}
+
+// Middleware adds the security headers to the responses. The routes can
+// change them with OverrideSecureHeaders.
+func (c SecureHeadersConfig) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		state := &secureHeadersState{config: c, nonce: newNonce()}
+		c.apply(w.Header(), state.nonce)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), secureHeadersKey{}, state)))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// SecureHeaders adds the security headers of DefaultSecureHeaders
+func SecureHeaders(next http.Handler) http.Handler {
+	return DefaultSecureHeaders.Middleware(next)
+}
+
+// OverrideSecureHeaders returns a middleware that changes the security
+// headers sent by the SecureHeaders middleware, for the routes it is
+// applied to. The modify function receives a copy of the configuration,
+// and the nonce of the request is kept:
+//
+//	embeddable := middleware.OverrideSecureHeaders(func(c *middleware.SecureHeadersConfig) {
+//		c.FrameOptions = ""
+//		c.CSP = c.CSP.Without("frame-ancestors")
+//	})
+//	r.Get("/embed", embeddable(h))
+func OverrideSecureHeaders(modify func(c *SecureHeadersConfig)) ion.Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			state, _ := r.Context().Value(secureHeadersKey{}).(*secureHeadersState)
+			if state == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			override := &secureHeadersState{config: state.config, nonce: state.nonce}
+			override.config.CSP = state.config.CSP.clone()
+			modify(&override.config)
+			override.config.apply(w.Header(), override.nonce)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), secureHeadersKey{}, override)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func nonceHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, CSPNonce(r.Context()))
+}
+
+func TestSecureHeaders(t *testing.T) {
+	h := SecureHeaders(http.HandlerFunc(nonceHandler))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	expected := map[string]string{
+		"Strict-Transport-Security":  "max-age=63072000; includeSubDomains",
+		"X-Content-Type-Options":     "nosniff",
+		"X-Frame-Options":            "DENY",
+		"Referrer-Policy":            "strict-origin-when-cross-origin",
+		"Cross-Origin-Opener-Policy": "same-origin",
+	}
+	for name, value := range expected {
+		if got := w.Header().Get(name); got != value {
+			t.Errorf("%s: expected %q, got %q", name, value, got)
+		}
+	}
+	nonce := w.Body.String()
+	csp := w.Header().Get("Content-Security-Policy")
+	if nonce == "" || !strings.Contains(csp, "script-src 'self' 'nonce-"+nonce+"'") {
+		t.Errorf("Expected the nonce %q in the CSP %q", nonce, csp)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Body.String() == nonce {
+		t.Error("Expected a new nonce for each request")
+	}
+}
+
+func TestSecureHeaders_Override(t *testing.T) {
+	config := SecureHeadersConfig{
+		NoSniff:       true,
+		FrameOptions:  "DENY",
+		CSP:           CSP{"default-src": {"'self'"}}.With("script-src", CSPNonceSource),
+		CSPReportOnly: true,
+	}
+	embeddable := OverrideSecureHeaders(func(c *SecureHeadersConfig) {
+		c.FrameOptions = ""
+		c.CSPReportOnly = false
+		c.CSP = c.CSP.With("frame-ancestors", "https://partner.test")
+	})
+	h := config.Middleware(embeddable(http.HandlerFunc(nonceHandler)))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Header().Get("X-Frame-Options") != "" || w.Header().Get("Content-Security-Policy-Report-Only") != "" {
+		t.Errorf("Expected the headers to be overridden, got %v", w.Header())
+	}
+	expected := "default-src 'self'; frame-ancestors https://partner.test; script-src 'nonce-" + w.Body.String() + "'"
+	if csp := w.Header().Get("Content-Security-Policy"); csp != expected {
+		t.Errorf("Expected CSP %q, got %q", expected, csp)
+	}
+	if config.CSP["frame-ancestors"] != nil {
+		t.Error("The override must not modify the configuration")
+	}
+
+	w = httptest.NewRecorder()
+	config.Middleware(http.HandlerFunc(nonceHandler)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Header().Get("Content-Security-Policy-Report-Only") == "" || w.Header().Get("Content-Security-Policy") != "" {
+		t.Errorf("Expected a report only CSP, got %v", w.Header())
+	}
+}
@@ -0,0 +1,138 @@
+package sessions
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Backend stores the sessions on the server, for example in memory or in
+// a database shared between replicas
+type Backend interface {
+	// Get returns the session with the given id, or ErrNotFound
+	Get(ctx context.Context, id string) ([]byte, error)
+	// Set stores the session with the given id. It may be discarded
+	// after ttl.
+	Set(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	// Delete removes the session with the given id
+	Delete(ctx context.Context, id string) error
+}
+
+// ServerStore is a Store that keeps the sessions in a Backend, sending
+// only their id in the cookie
+type ServerStore struct {
+	backend Backend
+}
+
+// NewServerStore creates a ServerStore that keeps the sessions in backend
+func NewServerStore(backend Backend) *ServerStore {
+	return &ServerStore{backend: backend}
+}
+
+// Load implements Store
+func (s *ServerStore) Load(ctx context.Context, cookie string) (*Data, error) {
+	b, err := s.backend.Get(ctx, cookie)
+	if err != nil {
+		return nil, err
+	}
+	d, err := DecodeData(b)
+	if err != nil {
+		return nil, err
+	}
+	if d.ID != cookie {
+		return nil, ErrNotFound
+	}
+	return d, nil
+}
+
+// Save implements Store
+func (s *ServerStore) Save(ctx context.Context, data *Data, ttl time.Duration) (string, error) {
+	b, err := data.Encode()
+	if err != nil {
+		return "", err
+	}
+	if err := s.backend.Set(ctx, data.ID, b, ttl); err != nil {
+		return "", err
+	}
+	return data.ID, nil
+}
+
+// Delete implements Store
+func (s *ServerStore) Delete(ctx context.Context, id string) error {
+	return s.backend.Delete(ctx, id)
+}
+
+// MemoryBackend is a Backend that keeps the sessions in memory.
+// The zero value is ready to use.
+type MemoryBackend struct {
+	l       sync.Mutex
+	entries map[string]memoryEntry
+	// nextPurge is when the expired sessions are removed next
+	nextPurge time.Time
+}
+
+// memoryPurgeInterval is how often MemoryBackend removes the expired
+// sessions. It is short compared to the lifetime of sessions, so they
+// do not accumulate.
+const memoryPurgeInterval = time.Minute
+
+type memoryEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemoryBackend creates a new MemoryBackend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{}
+}
+
+// Get implements Backend
+func (b *MemoryBackend) Get(ctx context.Context, id string) ([]byte, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	entry, ok := b.entries[id]
+	if ok && time.Now().After(entry.expires) {
+		delete(b.entries, id)
+		ok = false
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return entry.data, nil
+}
+
+// Set implements Backend
+func (b *MemoryBackend) Set(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	b.l.Lock()
+	defer b.l.Unlock()
+	now := time.Now()
+	if b.entries == nil {
+		b.entries = make(map[string]memoryEntry)
+	}
+	b.entries[id] = memoryEntry{data: data, expires: now.Add(ttl)}
+	if now.After(b.nextPurge) {
+		b.nextPurge = now.Add(memoryPurgeInterval)
+		for k, e := range b.entries {
+			if now.After(e.expires) {
+				delete(b.entries, k)
+			}
+		}
+	}
+	return nil
+}
+
+// Delete implements Backend
+func (b *MemoryBackend) Delete(ctx context.Context, id string) error {
+	b.l.Lock()
+	defer b.l.Unlock()
+	delete(b.entries, id)
+	return nil
+}
+
+// Len returns the number of sessions stored, including the expired ones
+// not purged yet
+func (b *MemoryBackend) Len() int {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return len(b.entries)
+}
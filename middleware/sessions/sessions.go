@@ -0,0 +1,419 @@
+// Package sessions keeps per-client state between requests, referenced by
+// a cookie.
+//
+// The sessions can be stored in the cookie itself, signed or encrypted, or
+// on the server through a Backend. They are loaded only when a handler asks
+// for them, and saved before the response is written if they changed:
+//
+//	config := sessions.Config{Store: sessions.NewServerStore(sessions.NewMemoryBackend())}
+//	h := config.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//		s, err := sessions.From(r.Context())
+//		if err != nil {
+//			http.Error(w, err.Error(), http.StatusInternalServerError)
+//			return
+//		}
+//		s.Set("user", "frank")
+//	}))
+//
+// The values must be encodable with encoding/gob, so custom types must be
+// registered with gob.Register.
+package sessions
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+	"github.com/estebarb/ion"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrNoSession is returned by From when the sessions middleware was not
+// applied to the request
+var ErrNoSession = errors.New("sessions: middleware not applied")
+
+// flashKey is the key of the flash messages in the session values
+const flashKey = "_flash"
+
+func init() {
+	gob.Register([]interface{}{})
+}
+
+// Session is the state of a client kept between requests. It is safe for
+// concurrent use.
+type Session struct {
+	l        sync.Mutex
+	data     *Data
+	isNew    bool
+	modified bool
+	// previous is the id of the stored session replaced by Regenerate
+	// or Destroy, that must be deleted
+	previous string
+}
+
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return encoding.EncodeToString(b)
+}
+
+func newSession(now time.Time) *Session {
+	return &Session{
+		data: &Data{
+			ID:       newID(),
+			Values:   make(map[string]interface{}),
+			Created:  now,
+			Accessed: now,
+		},
+		isNew: true,
+	}
+}
+
+// ID returns the id of the session
+func (s *Session) ID() string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.data.ID
+}
+
+// IsNew reports whether the session was started by the current request
+func (s *Session) IsNew() bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.isNew
+}
+
+// Created returns the time the session was started
+func (s *Session) Created() time.Time {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.data.Created
+}
+
+// Get returns the value stored with key, or nil
+func (s *Session) Get(key string) interface{} {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.data.Values[key]
+}
+
+// Set stores value with key
+func (s *Session) Set(key string, value interface{}) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.data.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes the value stored with key
+func (s *Session) Delete(key string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.data.Values[key]; ok {
+		delete(s.data.Values, key)
+		s.modified = true
+	}
+}
+
+// AddFlash adds a message that is kept until it is read with Flashes,
+// usually by the next request
+func (s *Session) AddFlash(value interface{}) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	flashes, _ := s.data.Values[flashKey].([]interface{})
+	s.data.Values[flashKey] = append(flashes, value)
+	s.modified = true
+}
+
+// Flashes returns the flash messages, removing them from the session
+func (s *Session) Flashes() []interface{} {
+	s.l.Lock()
+	defer s.l.Unlock()
+	flashes, ok := s.data.Values[flashKey].([]interface{})
+	if ok {
+		delete(s.data.Values, flashKey)
+		s.modified = true
+	}
+	return flashes
+}
+
+// Regenerate changes the id of the session, keeping its values. It must be
+// called when the privileges of the client change, like on login, to
+// prevent session fixation attacks.
+func (s *Session) Regenerate() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if !s.isNew && s.previous == "" {
+		s.previous = s.data.ID
+	}
+	s.data.ID = newID()
+	s.modified = true
+}
+
+// Destroy removes the session and its values, for example on logout. If
+// values are set afterwards a new session is started.
+func (s *Session) Destroy() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if !s.isNew && s.previous == "" {
+		s.previous = s.data.ID
+	}
+	now := s.data.Accessed
+	s.data = &Data{
+		ID:       newID(),
+		Values:   make(map[string]interface{}),
+		Created:  now,
+		Accessed: now,
+	}
+	s.isNew = true
+	s.modified = false
+}
+
+// Config configures the sessions middleware
+type Config struct {
+	// Store keeps the sessions
+	Store Store
+	// Name of the cookie. "session" is used if empty.
+	Name string
+	// Path and Domain of the cookie. Path defaults to "/".
+	Path   string
+	Domain string
+	// Secure restricts the cookie to HTTPS
+	Secure bool
+	// SameSite of the cookie. http.SameSiteLaxMode is used if zero.
+	SameSite http.SameSite
+	// Persistent sets the Max-Age of the cookie to the remaining lifetime
+	// of the session. Otherwise the cookie is removed when the browser
+	// is closed.
+	Persistent bool
+	// IdleTimeout expires the sessions not used for the given time.
+	// If both IdleTimeout and AbsoluteTimeout are zero, the sessions
+	// expire after 24 hours without use.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout expires the sessions after the given time since
+	// they were started, even if they are in use
+	AbsoluteTimeout time.Duration
+	// Now returns the current time. time.Now is used if nil.
+	Now func() time.Time
+}
+
+func (c Config) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+func (c Config) name() string {
+	if c.Name == "" {
+		return "session"
+	}
+	return c.Name
+}
+
+func (c Config) idleTimeout() time.Duration {
+	if c.IdleTimeout == 0 && c.AbsoluteTimeout == 0 {
+		return 24 * time.Hour
+	}
+	return c.IdleTimeout
+}
+
+// ttl returns the remaining lifetime of the session, if it is
+// saved at now
+func (c Config) ttl(d *Data, now time.Time) time.Duration {
+	ttl := c.idleTimeout()
+	if c.AbsoluteTimeout > 0 {
+		remaining := d.Created.Add(c.AbsoluteTimeout).Sub(now)
+		if ttl == 0 || remaining < ttl {
+			ttl = remaining
+		}
+	}
+	return ttl
+}
+
+func (c Config) expired(d *Data, now time.Time) bool {
+	if idle := c.idleTimeout(); idle > 0 && now.After(d.Accessed.Add(idle)) {
+		return true
+	}
+	return c.AbsoluteTimeout > 0 && now.After(d.Created.Add(c.AbsoluteTimeout))
+}
+
+// stale reports whether the access time of an unmodified session must be
+// refreshed, so that it does not expire while in use. It is refreshed at
+// most once per tenth of the idle timeout, to avoid saving it on
+// every request.
+func (c Config) stale(d *Data, now time.Time) bool {
+	idle := c.idleTimeout()
+	return idle > 0 && now.Sub(d.Accessed) > idle/10
+}
+
+type handleKey struct{}
+
+// handle loads the session of a request the first time it is requested,
+// and saves it before the response is written
+type handle struct {
+	config Config
+	r      *http.Request
+
+	l         sync.Mutex
+	loaded    bool
+	session   *Session
+	err       error
+	hasCookie bool
+	committed bool
+}
+
+func (h *handle) load() (*Session, error) {
+	h.l.Lock()
+	defer h.l.Unlock()
+	if h.loaded {
+		return h.session, h.err
+	}
+	h.loaded = true
+	now := h.config.now()
+	h.session = newSession(now)
+
+	cookie, err := h.r.Cookie(h.config.name())
+	if err != nil || cookie.Value == "" {
+		return h.session, nil
+	}
+	h.hasCookie = true
+	data, err := h.config.Store.Load(h.r.Context(), cookie.Value)
+	switch {
+	case errors.Is(err, ErrNotFound):
+	case err != nil:
+		h.session, h.err = nil, err
+	case h.config.expired(data, now):
+		h.session.previous = data.ID
+	default:
+		h.session.data = data
+		h.session.isNew = false
+	}
+	return h.session, h.err
+}
+
+// commit saves the session, if it was loaded, and sets the cookie
+func (h *handle) commit(w http.ResponseWriter) {
+	h.l.Lock()
+	defer h.l.Unlock()
+	if h.committed || h.session == nil {
+		return
+	}
+	h.committed = true
+	s := h.session
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	ctx := h.r.Context()
+	logger := ion.LoggerFrom(ctx)
+	if s.previous != "" {
+		if err := h.config.Store.Delete(ctx, s.previous); err != nil {
+			logger.Error("session delete", "error", err.Error())
+		}
+		s.previous = ""
+	}
+
+	now := h.config.now()
+	cookie := &http.Cookie{
+		Name:     h.config.name(),
+		Path:     h.config.Path,
+		Domain:   h.config.Domain,
+		Secure:   h.config.Secure,
+		HttpOnly: true,
+		SameSite: h.config.SameSite,
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+
+	if !s.modified && (s.isNew || !h.config.stale(s.data, now)) {
+		if s.isNew && h.hasCookie {
+			// Remove the cookie of an expired or destroyed session
+			cookie.MaxAge = -1
+			http.SetCookie(w, cookie)
+		}
+		return
+	}
+
+	if s.isNew {
+		s.data.Created = now
+	}
+	s.data.Accessed = now
+	ttl := h.config.ttl(s.data, now)
+	value, err := h.config.Store.Save(ctx, s.data, ttl)
+	if err != nil {
+		logger.Error("session save", "error", err.Error())
+		return
+	}
+	cookie.Value = value
+	if h.config.Persistent {
+		cookie.MaxAge = int((ttl + time.Second - 1) / time.Second)
+	}
+	http.SetCookie(w, cookie)
+	s.modified = false
+	s.isNew = false
+}
+
+// From returns the session of the request, loading it from the Store the
+// first time. A new session is returned if the client has none, or it
+// expired. An error is returned if the Store fails.
+func From(ctx context.Context) (*Session, error) {
+	h, ok := ctx.Value(handleKey{}).(*handle)
+	if !ok {
+		return nil, ErrNoSession
+	}
+	return h.load()
+}
+
+// Middleware makes the sessions available to the handlers through From.
+// The sessions are loaded lazily, and saved before the headers of the
+// response are written if they were modified.
+func (c Config) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		h := &handle{config: c}
+		r = r.WithContext(context.WithValue(r.Context(), handleKey{}, h))
+		h.r = r
+		sw := &sessionWriter{ResponseWriter: w, h: h}
+		next.ServeHTTP(sw, r)
+		h.commit(w)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// sessionWriter saves the session before the headers are written
+type sessionWriter struct {
+	http.ResponseWriter
+	h *handle
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	w.h.commit(w.ResponseWriter)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.h.commit(w.ResponseWriter)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Flush() {
+	w.h.commit(w.ResponseWriter)
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *sessionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,229 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// client sends requests keeping the cookies, like a browser
+type client struct {
+	h       http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func (c *client) get(t *testing.T, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	c.h.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(c.cookies, cookie.Name)
+		} else {
+			c.cookies[cookie.Name] = cookie
+		}
+	}
+	return w
+}
+
+// app is a handler that uses the sessions as a counter, and logs in and
+// out the clients
+func app(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := From(r.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch r.URL.Path {
+		case "/count":
+			n, _ := s.Get("n").(int)
+			s.Set("n", n+1)
+			fmt.Fprint(w, n+1)
+		case "/login":
+			s.Regenerate()
+			s.Set("user", "frank")
+			s.AddFlash("welcome")
+		case "/logout":
+			s.Destroy()
+		case "/flashes":
+			fmt.Fprint(w, s.Flashes())
+		default:
+			fmt.Fprint(w, s.Get("user"))
+		}
+	})
+}
+
+func stores(t *testing.T) map[string]Store {
+	encrypted, err := NewEncryptedCookieStore(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store{
+		"signed":    NewSignedCookieStore([]byte("secret")),
+		"encrypted": encrypted,
+		"server":    NewServerStore(NewMemoryBackend()),
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	for name, store := range stores(t) {
+		c := &client{
+			h:       Config{Store: store}.Middleware(app(t)),
+			cookies: make(map[string]*http.Cookie),
+		}
+		if w := c.get(t, "/user"); w.Header().Get("Set-Cookie") != "" {
+			t.Errorf("%s: unmodified new sessions must not be saved", name)
+		}
+		for i := 1; i <= 3; i++ {
+			if w := c.get(t, "/count"); w.Body.String() != fmt.Sprint(i) {
+				t.Errorf("%s: expected %d, got %q", name, i, w.Body.String())
+			}
+		}
+		id := c.cookies["session"].Value
+		c.get(t, "/login")
+		if c.cookies["session"].Value == id {
+			t.Errorf("%s: expected the session to be rotated on login", name)
+		}
+		if w := c.get(t, "/user"); w.Body.String() != "frank" {
+			t.Errorf("%s: expected user frank, got %q", name, w.Body.String())
+		}
+		if w := c.get(t, "/flashes"); w.Body.String() != "[welcome]" {
+			t.Errorf("%s: unexpected flashes %q", name, w.Body.String())
+		}
+		if w := c.get(t, "/flashes"); w.Body.String() != "[]" {
+			t.Errorf("%s: flashes must be read once, got %q", name, w.Body.String())
+		}
+		c.get(t, "/logout")
+		if _, ok := c.cookies["session"]; ok {
+			t.Errorf("%s: expected the cookie to be removed on logout", name)
+		}
+		if w := c.get(t, "/count"); w.Body.String() != "1" {
+			t.Errorf("%s: expected a new session, got %q", name, w.Body.String())
+		}
+	}
+}
+
+func TestServerStore_Rotation(t *testing.T) {
+	backend := NewMemoryBackend()
+	c := &client{
+		h:       Config{Store: NewServerStore(backend)}.Middleware(app(t)),
+		cookies: make(map[string]*http.Cookie),
+	}
+	c.get(t, "/count")
+	fixed := c.cookies["session"]
+	c.get(t, "/login")
+	c.get(t, "/logout")
+	if backend.Len() != 0 {
+		t.Errorf("Expected the replaced sessions to be deleted, %d left", backend.Len())
+	}
+
+	// The id from before the login must not be valid anymore
+	c.cookies["session"] = fixed
+	if w := c.get(t, "/user"); w.Body.String() != "<nil>" {
+		t.Errorf("Expected an anonymous session, got %q", w.Body.String())
+	}
+}
+
+func TestMemoryBackend_Purge(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+	backend.Set(ctx, "old", []byte("a"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	backend.Set(ctx, "new", []byte("b"), time.Minute)
+	if backend.Len() != 2 {
+		t.Errorf("Expected no purge before the interval, got %d sessions", backend.Len())
+	}
+	if _, err := backend.Get(ctx, "old"); err != ErrNotFound || backend.Len() != 1 {
+		t.Errorf("Expected the expired session to be removed when read, got %v", err)
+	}
+
+	backend.Set(ctx, "old", []byte("a"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	backend.nextPurge = time.Time{}
+	backend.Set(ctx, "other", []byte("c"), time.Minute)
+	if backend.Len() != 2 {
+		t.Errorf("Expected the expired session to be purged, got %d sessions", backend.Len())
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	config := Config{
+		Store:           NewSignedCookieStore([]byte("secret")),
+		IdleTimeout:     10 * time.Minute,
+		AbsoluteTimeout: time.Hour,
+		Persistent:      true,
+		Now:             func() time.Time { return now },
+	}
+	c := &client{h: config.Middleware(app(t)), cookies: make(map[string]*http.Cookie)}
+	c.get(t, "/count")
+	if c.cookies["session"].MaxAge != 600 {
+		t.Errorf("Expected Max-Age 600, got %d", c.cookies["session"].MaxAge)
+	}
+
+	// Reading the session keeps it alive
+	for i := 0; i < 10; i++ {
+		now = now.Add(5 * time.Minute)
+		c.get(t, "/user")
+	}
+	if w := c.get(t, "/count"); w.Body.String() != "2" {
+		t.Errorf("Expected the session to be kept alive, got %q", w.Body.String())
+	}
+	if c.cookies["session"].MaxAge != 600 {
+		t.Errorf("Expected Max-Age 600, got %d", c.cookies["session"].MaxAge)
+	}
+
+	now = now.Add(11 * time.Minute)
+	if w := c.get(t, "/count"); w.Body.String() != "1" {
+		t.Errorf("Expected an idle session to expire, got %q", w.Body.String())
+	}
+
+	for i := 0; i < 12; i++ {
+		now = now.Add(5 * time.Minute)
+		c.get(t, "/count")
+	}
+	now = now.Add(time.Minute)
+	if w := c.get(t, "/count"); w.Body.String() != "1" {
+		t.Errorf("Expected the session to expire after an hour, got %q", w.Body.String())
+	}
+}
+
+func TestCookieStore_Tampering(t *testing.T) {
+	ctx := context.Background()
+	signed := NewSignedCookieStore([]byte("new"), []byte("old"))
+	old := NewSignedCookieStore([]byte("old"))
+	data := &Data{ID: "id", Values: map[string]interface{}{"user": "frank"}}
+
+	cookie, _ := old.Save(ctx, data, time.Hour)
+	if d, err := signed.Load(ctx, cookie); err != nil || d.Values["user"] != "frank" {
+		t.Errorf("Expected cookies signed with old keys to be accepted, got %v", err)
+	}
+	forged := strings.Replace(cookie, cookie[:4], "AAAA", 1)
+	if _, err := signed.Load(ctx, forged); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a tampered cookie, got %v", err)
+	}
+
+	encrypted, _ := NewEncryptedCookieStore(make([]byte, 16))
+	if _, err := encrypted.Load(ctx, cookie); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a signed cookie, got %v", err)
+	}
+	cookie, _ = encrypted.Save(ctx, data, time.Hour)
+	if strings.Contains(cookie, "frank") {
+		t.Error("Expected the cookie to be encrypted")
+	}
+
+	data.Values["big"] = strings.Repeat("x", 5000)
+	if _, err := encrypted.Save(ctx, data, time.Hour); err != ErrCookieTooLarge {
+		t.Errorf("Expected ErrCookieTooLarge, got %v", err)
+	}
+	if _, err := NewEncryptedCookieStore([]byte("short")); err == nil {
+		t.Error("Expected an error for an invalid key")
+	}
+}
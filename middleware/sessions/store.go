@@ -0,0 +1,205 @@
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Errors returned by the stores
+var (
+	// ErrNotFound is returned when the session does not exist, or the
+	// cookie does not reference a valid session
+	ErrNotFound = errors.New("sessions: session not found")
+	// ErrCookieTooLarge is returned by the cookie stores when the session
+	// does not fit in a cookie
+	ErrCookieTooLarge = errors.New("sessions: session too large for a cookie")
+)
+
+// maxCookieSize is the size of the largest cookie value that browsers are
+// required to accept, leaving room for the name and attributes
+const maxCookieSize = 3900
+
+// Data is the stored state of a session
+type Data struct {
+	ID     string
+	Values map[string]interface{}
+	// Created is the time the session was started
+	Created time.Time
+	// Accessed is the last time the session was saved
+	Accessed time.Time
+}
+
+// Encode serializes the data with encoding/gob
+func (d *Data) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(d); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeData deserializes data serialized with Data.Encode
+func DecodeData(b []byte) (*Data, error) {
+	d := &Data{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(d); err != nil {
+		return nil, err
+	}
+	if d.Values == nil {
+		d.Values = make(map[string]interface{})
+	}
+	return d, nil
+}
+
+// Store loads and saves the sessions referenced by the session cookies
+type Store interface {
+	// Load returns the session referenced by the cookie value, or
+	// ErrNotFound if there is none
+	Load(ctx context.Context, cookie string) (*Data, error)
+	// Save stores the session for ttl, returning the cookie value that
+	// references it
+	Save(ctx context.Context, data *Data, ttl time.Duration) (string, error)
+	// Delete removes the session with the given id
+	Delete(ctx context.Context, id string) error
+}
+
+var encoding = base64.RawURLEncoding
+
+// CookieStore is a Store that keeps the whole session in the cookie, so
+// no server-side storage is needed. As the cookies stay valid until they
+// expire, destroyed or rotated sessions cannot be revoked.
+type CookieStore struct {
+	keys    [][]byte
+	aeads   []cipher.AEAD
+	encrypt bool
+}
+
+// NewSignedCookieStore creates a CookieStore that signs the cookies with
+// HMAC-SHA256, so that clients can read them but not modify them. The
+// first key signs the cookies, and all of them are accepted when loading,
+// to allow rotating the keys.
+func NewSignedCookieStore(keys ...[]byte) *CookieStore {
+	return &CookieStore{keys: keys}
+}
+
+// NewEncryptedCookieStore creates a CookieStore that encrypts the cookies
+// with AES-GCM, so that clients can neither read nor modify them. The keys
+// must be 16, 24 or 32 bytes long. The first key encrypts the cookies,
+// and all of them are accepted when loading, to allow rotating the keys.
+func NewEncryptedCookieStore(keys ...[]byte) (*CookieStore, error) {
+	s := &CookieStore{encrypt: true}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		s.aeads = append(s.aeads, aead)
+	}
+	return s, nil
+}
+
+func (s *CookieStore) sign(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Load implements Store
+func (s *CookieStore) Load(ctx context.Context, cookie string) (*Data, error) {
+	payload, ok := s.open(cookie)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	d, err := DecodeData(payload)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return d, nil
+}
+
+func (s *CookieStore) open(cookie string) ([]byte, bool) {
+	if s.encrypt {
+		sealed, err := encoding.DecodeString(cookie)
+		if err != nil {
+			return nil, false
+		}
+		for _, aead := range s.aeads {
+			if len(sealed) < aead.NonceSize() {
+				continue
+			}
+			nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+			if payload, err := aead.Open(nil, nonce, ciphertext, nil); err == nil {
+				return payload, true
+			}
+		}
+		return nil, false
+	}
+
+	i := strings.IndexByte(cookie, '.')
+	if i < 0 {
+		return nil, false
+	}
+	payload, err := encoding.DecodeString(cookie[:i])
+	if err != nil {
+		return nil, false
+	}
+	signature, err := encoding.DecodeString(cookie[i+1:])
+	if err != nil {
+		return nil, false
+	}
+	for _, key := range s.keys {
+		if hmac.Equal(s.sign(key, payload), signature) {
+			return payload, true
+		}
+	}
+	return nil, false
+}
+
+// Save implements Store
+func (s *CookieStore) Save(ctx context.Context, data *Data, ttl time.Duration) (string, error) {
+	payload, err := data.Encode()
+	if err != nil {
+		return "", err
+	}
+	var cookie string
+	if s.encrypt {
+		if len(s.aeads) == 0 {
+			return "", errors.New("sessions: no encryption keys")
+		}
+		aead := s.aeads[0]
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		cookie = encoding.EncodeToString(aead.Seal(nonce, nonce, payload, nil))
+	} else {
+		if len(s.keys) == 0 {
+			return "", errors.New("sessions: no signing keys")
+		}
+		cookie = encoding.EncodeToString(payload) + "." +
+			encoding.EncodeToString(s.sign(s.keys[0], payload))
+	}
+	if len(cookie) > maxCookieSize {
+		return "", ErrCookieTooLarge
+	}
+	return cookie, nil
+}
+
+// Delete implements Store. It does nothing, as the sessions are only
+// stored in the cookies.
+func (s *CookieStore) Delete(ctx context.Context, id string) error {
+	return nil
+}
@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"github.com/estebarb/ion"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TimeoutConfig configures the time given to handlers to answer requests
+type TimeoutConfig struct {
+	// Timeout is the time given to the handler
+	Timeout time.Duration
+	// Status is the status code of the response sent when the handler
+	// takes too long. http.StatusServiceUnavailable is used if zero.
+	Status int
+	// Body, if not empty, is sent as plain text when the handler takes too
+	// long. Otherwise an error is sent in the format requested by the
+	// client.
+	Body string
+}
+
+// Timeout returns a middleware that gives the wrapped handler d to answer
+// the requests, using the default TimeoutConfig
+func Timeout(d time.Duration) ion.Middleware {
+	return TimeoutConfig{Timeout: d}.Middleware
+}
+
+// Middleware runs the wrapped handler with a context that expires after
+// Timeout. If the handler has not finished by then, an error response is
+// sent and later writes of the handler fail with http.ErrHandlerTimeout.
+// The response of the handler is buffered until it finishes or flushes it;
+// once flushed the response cannot be replaced by the timeout error.
+//
+// Routes can change the timeout of the requests they handle with
+// ion.Endpoint.Timeout, router.Route.Timeout or ion.OverrideTimeout.
+func (c TimeoutConfig) Middleware(next http.Handler) http.Handler {
+	status := c.Status
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := newDeadlineContext(r.Context(), c.Timeout)
+		defer ctx.cancel(context.Canceled)
+		r = r.WithContext(ion.WithTimeoutOverride(ctx, ctx.reset))
+
+		tw := &timeoutWriter{w: w, header: make(http.Header), ctx: ctx}
+		done := make(chan struct{})
+		panicChan := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicChan <- p
+				}
+			}()
+			next.ServeHTTP(tw, r)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicChan:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			if !tw.timedOut {
+				tw.commit()
+				return
+			}
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+		}
+		if ctx.Err() != context.DeadlineExceeded || tw.committed {
+			// The client went away, or the response was already
+			// started by the handler
+			return
+		}
+		if c.Body != "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(status)
+			w.Write([]byte(c.Body))
+		} else {
+			ion.WriteError(w, r, status, "request timed out", "")
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// timeoutWriter buffers the response of the handler until it is committed
+// to the client, protecting it from writes after the timeout
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+	buf    bytes.Buffer
+	ctx    context.Context
+
+	mu        sync.Mutex
+	status    int
+	committed bool
+	timedOut  bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expired() || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
+
+// expired reports whether the response can no longer be written by the
+// handler. It must be called with the lock held.
+func (tw *timeoutWriter) expired() bool {
+	if !tw.committed && tw.ctx.Err() == context.DeadlineExceeded {
+		tw.timedOut = true
+	}
+	return tw.timedOut
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expired() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	if tw.committed {
+		return tw.w.Write(b)
+	}
+	return tw.buf.Write(b)
+}
+
+// Flush sends the buffered response to the client. After that the
+// response cannot be replaced by the timeout error.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expired() {
+		return
+	}
+	tw.commit()
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// commit sends the headers and the buffered body. It must be called with
+// the lock held.
+func (tw *timeoutWriter) commit() {
+	if !tw.committed {
+		tw.committed = true
+		dst := tw.w.Header()
+		for k, v := range tw.header {
+			dst[k] = v
+		}
+		if tw.status == 0 {
+			tw.status = http.StatusOK
+		}
+		tw.w.WriteHeader(tw.status)
+	}
+	if tw.buf.Len() > 0 {
+		tw.w.Write(tw.buf.Bytes())
+		tw.buf.Reset()
+	}
+}
+
+// deadlineContext is a context whose deadline can be moved, so that routes
+// can override the timeout, also extending it
+type deadlineContext struct {
+	context.Context
+	mu       sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+	done     chan struct{}
+	err      error
+}
+
+func newDeadlineContext(parent context.Context, d time.Duration) *deadlineContext {
+	ctx := &deadlineContext{
+		Context:  parent,
+		deadline: time.Now().Add(d),
+		done:     make(chan struct{}),
+	}
+	ctx.mu.Lock()
+	ctx.timer = time.AfterFunc(d, ctx.expire)
+	ctx.mu.Unlock()
+	go func() {
+		select {
+		case <-parent.Done():
+			ctx.cancel(parent.Err())
+		case <-ctx.done:
+		}
+	}()
+	return ctx
+}
+
+func (ctx *deadlineContext) reset(d time.Duration) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.err != nil {
+		return
+	}
+	ctx.deadline = time.Now().Add(d)
+	ctx.timer.Reset(d)
+}
+
+// expire cancels the context if the deadline was not moved after the
+// timer fired
+func (ctx *deadlineContext) expire() {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.err == nil && !time.Now().Before(ctx.deadline) {
+		ctx.cancelLocked(context.DeadlineExceeded)
+	}
+}
+
+func (ctx *deadlineContext) cancel(err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.cancelLocked(err)
+}
+
+func (ctx *deadlineContext) cancelLocked(err error) {
+	if ctx.err != nil {
+		return
+	}
+	ctx.err = err
+	ctx.timer.Stop()
+	close(ctx.done)
+}
+
+func (ctx *deadlineContext) Deadline() (time.Time, bool) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if parent, ok := ctx.Context.Deadline(); ok && parent.Before(ctx.deadline) {
+		return parent, true
+	}
+	return ctx.deadline, true
+}
+
+func (ctx *deadlineContext) Done() <-chan struct{} {
+	return ctx.done
+}
+
+func (ctx *deadlineContext) Err() error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.err
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"github.com/estebarb/ion"
+	"github.com/estebarb/ion/components/router"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func sleepHandler(d time.Duration, late chan error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+		}
+		_, err := w.Write([]byte("done"))
+		if late != nil {
+			late <- err
+		}
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	late := make(chan error, 1)
+	h := TimeoutConfig{
+		Timeout: 20 * time.Millisecond,
+		Status:  http.StatusGatewayTimeout,
+		Body:    "too slow",
+	}.Middleware(sleepHandler(time.Second, late))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusGatewayTimeout || w.Body.String() != "too slow" {
+		t.Errorf("Expected the timeout response, got %d %q", w.Code, w.Body.String())
+	}
+	if err := <-late; err != http.ErrHandlerTimeout {
+		t.Errorf("Expected late writes to fail with ErrHandlerTimeout, got %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	Timeout(time.Second)(sleepHandler(0, nil)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Errorf("Expected the handler response, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestTimeout_Deadline(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	h := Timeout(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok = r.Context().Deadline()
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !ok || time.Until(deadline) < 59*time.Second {
+		t.Errorf("Expected a deadline in a minute, got %v", deadline)
+	}
+}
+
+func TestTimeout_RouteOverride(t *testing.T) {
+	r := router.New()
+	r.Get("/slow", sleepHandler(50*time.Millisecond, nil)).Timeout(time.Second)
+	r.Get("/fast", sleepHandler(50*time.Millisecond, nil))
+	h := Timeout(20 * time.Millisecond)(r)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the route timeout to extend the deadline, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a timeout, got %d", w.Code)
+	}
+}
+
+func TestTimeout_EndpointOverride(t *testing.T) {
+	h := Timeout(time.Second)(ion.Endpoint{
+		HttpHandler: sleepHandler(time.Second, nil),
+		Timeout:     10 * time.Millisecond,
+	}.Build())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the endpoint timeout to shorten the deadline, got %d", w.Code)
+	}
+}
+
+func TestTimeout_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	Timeout(time.Second)(sleepHandler(time.Second, nil)).ServeHTTP(w, req)
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected no response for a canceled request, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,71 @@
+package ion
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AcceptSpec is a value of an Accept or Accept-Encoding header, with its
+// quality
+type AcceptSpec struct {
+	Value string
+	Q     float64
+}
+
+// ParseAccept parses the values of an Accept or Accept-Encoding header.
+// The values are lower cased.
+func ParseAccept(header string) []AcceptSpec {
+	var specs []AcceptSpec
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		value := strings.ToLower(strings.TrimSpace(fields[0]))
+		if value == "" {
+			continue
+		}
+		spec := AcceptSpec{Value: value, Q: 1}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					spec.Q = q
+				}
+			}
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// NegotiateContentType returns the offered media type preferred by the
+// request Accept header. The first offer is returned if the header is absent,
+// and an empty string if no offer is acceptable.
+func NegotiateContentType(r *http.Request, offers ...string) string {
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return offers[0]
+	}
+	best, bestQ, bestSpecificity := "", 0.0, -1
+	for _, spec := range ParseAccept(header) {
+		for _, offer := range offers {
+			specificity := -1
+			switch {
+			case spec.Value == offer:
+				specificity = 2
+			case strings.HasSuffix(spec.Value, "/*") &&
+				strings.HasPrefix(offer, strings.TrimSuffix(spec.Value, "*")):
+				specificity = 1
+			case spec.Value == "*/*":
+				specificity = 0
+			}
+			if specificity < 0 || spec.Q <= 0 {
+				continue
+			}
+			if spec.Q > bestQ || (spec.Q == bestQ && specificity > bestSpecificity) {
+				best, bestQ, bestSpecificity = offer, spec.Q, specificity
+			}
+		}
+	}
+	return best
+}
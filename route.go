@@ -0,0 +1,82 @@
+package ion
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// routeInfo records the routes matched while handling a request. It is
+// stored by pointer, so middleware that wraps a router can read what the
+// router matched after the handler returns.
+type routeInfo struct {
+	sync.Mutex
+	pattern string
+	name    string
+}
+
+type routeInfoKey struct{}
+
+func routeInfoFrom(ctx context.Context) *routeInfo {
+	info, _ := ctx.Value(routeInfoKey{}).(*routeInfo)
+	return info
+}
+
+// TrackRoute returns a request whose context records the routes matched
+// while it is handled. Middleware calls it before the next handler to be able
+// to use RoutePattern and RouteName afterwards. If the request already tracks
+// its routes it is returned unchanged.
+func TrackRoute(r *http.Request) *http.Request {
+	if routeInfoFrom(r.Context()) != nil {
+		return r
+	}
+	ctx := context.WithValue(r.Context(), routeInfoKey{}, &routeInfo{})
+	return r.WithContext(ctx)
+}
+
+// MatchRoute records that a route with the given pattern, relative to the
+// patterns matched by enclosing routers, handles the request. The name is
+// recorded if not empty.
+func MatchRoute(r *http.Request, pattern, name string) *http.Request {
+	r = TrackRoute(r)
+	info := routeInfoFrom(r.Context())
+	info.Lock()
+	info.pattern = joinPattern(info.pattern, pattern)
+	if name != "" {
+		info.name = name
+	}
+	info.Unlock()
+	return r
+}
+
+func joinPattern(base, pattern string) string {
+	if base == "" {
+		return pattern
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(pattern, "/")
+}
+
+// RoutePattern returns the pattern of the route that handled the request, or
+// an empty string if unknown.
+func RoutePattern(ctx context.Context) string {
+	info := routeInfoFrom(ctx)
+	if info == nil {
+		return ""
+	}
+	info.Lock()
+	defer info.Unlock()
+	return info.pattern
+}
+
+// RouteName returns the name of the route that handled the request, or
+// an empty string if unknown.
+func RouteName(ctx context.Context) string {
+	info := routeInfoFrom(ctx)
+	if info == nil {
+		return ""
+	}
+	info.Lock()
+	defer info.Unlock()
+	return info.name
+}
@@ -0,0 +1,27 @@
+package ion
+
+import (
+	"context"
+	"time"
+)
+
+type timeoutKey struct{}
+
+// WithTimeoutOverride returns a copy of ctx that allows routes to change
+// the time given to handle the request through OverrideTimeout. The set
+// function receives the new duration, counted from the moment it is called.
+// It is meant to be used by timeout middleware.
+func WithTimeoutOverride(ctx context.Context, set func(d time.Duration)) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, set)
+}
+
+// OverrideTimeout gives d more time to handle the request, replacing the
+// duration configured in the timeout middleware. It reports whether the
+// request is handled by a timeout middleware that supports overrides.
+func OverrideTimeout(ctx context.Context, d time.Duration) bool {
+	set, ok := ctx.Value(timeoutKey{}).(func(time.Duration))
+	if ok {
+		set(d)
+	}
+	return ok
+}